@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/weaveworks/kured/pkg/controller"
+	"github.com/weaveworks/kured/pkg/delaytick"
+	"github.com/weaveworks/kured/pkg/errorclass"
+	"github.com/weaveworks/kured/pkg/nodestate"
+	"github.com/weaveworks/kured/pkg/rebootrequest"
+)
+
+// agentRebootAsRequired reports whether the node requires a reboot through
+// node annotations, and drains and reboots it once a kured controller has
+// approved the reboot. Windows, blockers and the lock are left to the
+// controller. Once ctx is done, a drain in progress is abandoned, and the
+// approval given up.
+func agentRebootAsRequired(ctx context.Context, nodeID string, cfg *config, policies *policyWatcher, reboots *requestWatcher) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	node, err := client.CoreV1().Nodes().Get(ctx, nodeID, metav1.GetOptions{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	history := newRebootHistory(client)
+	if history != nil {
+		go maintainRateLimitMetrics(ctx, history)
+	}
+	ns := &nodeSettings{client: client, nodeID: nodeID, rebooting: rebootingApproved, cfg: cfg, policies: policies}
+	if err := ns.update(node); err != nil {
+		log.Fatal(err)
+	}
+
+	states, err := nodestate.New(client, node)
+	if err != nil {
+		log.Warnf("Ignoring recorded state of node %s: %v", nodeID, err)
+	}
+
+	// rebootNode drains and reboots the node once approved. It returns an
+	// error if the drain is abandoned on shutdown or after it failed, and nil
+	// once ctx is done after commanding the reboot.
+	rebootNode := func(node *v1.Node, nodeMeta *nodeMeta, requests []*rebootrequest.NodeRebootRequest, drainNode bool) error {
+		track := func(phase rebootrequest.Phase, message string) {
+			if reboots != nil {
+				reboots.track(ctx, requests, node, phase, message)
+			}
+		}
+
+		if drainNode && !nodeMeta.Unschedulable {
+			track(rebootrequest.Draining, "")
+			enterState(ctx, states, nodestate.Draining, "")
+			if err := drain(ctx, client, node, time.Time{}, ns.settings.drain, ns.settings.notifiers); err != nil {
+				countError(err)
+				return err
+			}
+		}
+		if len(nodeMeta.SilenceIDs) == 0 {
+			silenceNodeAlerts(nodeMeta, nodeID)
+			if len(nodeMeta.SilenceIDs) > 0 {
+				if err := recordRebootInProgress(ctx, client, nodeID, nodeMeta); err != nil {
+					log.Warnf("Error recording silences: %v", err)
+				}
+			}
+		}
+		logRequests(requests)
+		track(rebootrequest.Rebooting, "")
+		// The controller recorded the reboot in the history on approval
+		enterState(ctx, states, nodestate.Rebooting, "")
+		commandReboot(nodeID, ns.settings.notifiers)
+		for {
+			log.Infof("Waiting for reboot")
+			select {
+			case <-ctx.Done():
+				// Most likely terminated by the reboot itself
+				return nil
+			case <-time.After(time.Minute):
+			}
+		}
+	}
+
+	// abandonReboot uncordons the node and gives up the approval after its
+	// drain was abandoned, leaving the controller to approve the reboot
+	// again. This must complete even if kured is shutting down, within the
+	// grace period of its pod.
+	abandonReboot := func(node *v1.Node, requests []*rebootrequest.NodeRebootRequest, err error) {
+		reason := drainAbandoned(ctx, err)
+		log.Warnf("Abandoning reboot of node %s: %s", nodeID, reason)
+		cleanupCtx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+		defer cancel()
+		if err := uncordon(cleanupCtx, client, node); err != nil {
+			giveUp(err)
+		}
+		if err := controller.PatchNodeAnnotations(cleanupCtx, client, nodeID, map[string]*string{
+			controller.RebootInProgressAnnotation: nil,
+			controller.RebootApprovedAnnotation:   nil,
+		}); err != nil {
+			log.Warnf("Error giving up approval: %v", err)
+		}
+		if reboots != nil {
+			if drainFailed(ctx, err) {
+				// The drain is retried for the reboot sentinel only
+				reboots.track(cleanupCtx, requests, node, rebootrequest.Failed, fmt.Sprintf("drain failed: %v", err))
+			} else {
+				reboots.track(cleanupCtx, requests, node, rebootrequest.Pending, reason)
+			}
+		}
+		enterState(cleanupCtx, states, nodestate.RebootRequired, reason)
+		if errorclass.Denied(err) {
+			// Retrying is pointless until the permissions of kured are fixed
+			log.Fatalf("Error draining %s: %v", nodeID, err)
+		}
+	}
+
+	// resume picks up where the previous kured pod left off: it resumes a
+	// drain or reboot interrupted by a restart, or completes the reboot once
+	// the node is back. It returns false if an error left this to be tried
+	// again at the next check, and true once ctx is done, which the caller
+	// checks.
+	var unhealthy error
+	verified := false
+	resume := func(node *v1.Node) bool {
+		meta := nodeMeta{}
+		value, inProgress := node.Annotations[controller.RebootInProgressAnnotation]
+		if inProgress {
+			if err := json.Unmarshal([]byte(value), &meta); err != nil {
+				log.Warnf("Invalid %s annotation: %v", controller.RebootInProgressAnnotation, err)
+			}
+		}
+
+		if state := states.State(); inProgress && !verified && (state == nodestate.Draining || state == nodestate.Rebooting) && !meta.rebooted(node) {
+			// kured was restarted before the node went down
+			log.Infof("Resuming reboot of node %s from state %s", nodeID, state)
+			var requests []*rebootrequest.NodeRebootRequest
+			if reboots != nil {
+				requests = reboots.due(ctx, node)
+			}
+			if err := rebootNode(node, &meta, requests, state == nodestate.Draining); err != nil {
+				abandonReboot(node, requests, err)
+			}
+			return true
+		} else if inProgress {
+			// Back from a reboot, whichever state was recorded before
+			if !verified {
+				if err := states.Reset(ctx, nodestate.Verifying, ""); err != nil {
+					log.Warnf("Error recording state of node %s: %v", nodeID, err)
+				}
+				if !meta.Unschedulable {
+					if err := uncordon(ctx, client, node); err != nil {
+						giveUp(err)
+						return false
+					}
+				}
+				expireNodeAlertSilences(&meta)
+				// Keep the reboot in progress until the node is healthy
+				unhealthy = checkRebootHealth(ctx, client, nodeID, ns.registry, ns.settings)
+				if unhealthy == ctx.Err() {
+					// Left to the next kured pod, which will find the reboot in progress
+					log.Infof("Shutting down before node %s is healthy", nodeID)
+					return true
+				} else if unhealthy != nil {
+					enterState(ctx, states, nodestate.Failed, unhealthy.Error())
+				} else {
+					enterState(ctx, states, nodestate.Done, "")
+				}
+				if meta.rebooted(node) {
+					recordRebootOutcome(ctx, history, nodeID, unhealthy, ns.settings.notifiers)
+				}
+				verified = true
+			}
+			if err := controller.PatchNodeAnnotations(ctx, client, nodeID, map[string]*string{
+				controller.RebootInProgressAnnotation: nil,
+				controller.RebootApprovedAnnotation:   nil,
+				// Reported again below if the node still requires a reboot
+				controller.RebootRequiredAnnotation: nil,
+			}); err != nil {
+				giveUp(fmt.Errorf("Error completing reboot: %w", err))
+				return false
+			}
+			// Without a reboot, e.g. after a failed drain, nothing completed
+			if meta.rebooted(node) {
+				recordRebootCompleted(ctx, client, nodeID)
+			}
+		} else if state == nodestate.Draining || state == nodestate.Rebooting || state == nodestate.Verifying {
+			// The reboot was withdrawn meanwhile
+			if err := states.Reset(ctx, nodestate.Failed, "reboot abandoned, no longer in progress"); err != nil {
+				log.Warnf("Error recording state of node %s: %v", nodeID, err)
+			}
+		}
+		if reboots != nil {
+			reboots.complete(ctx, node, unhealthy)
+		}
+		return true
+	}
+
+	resumed := resume(node)
+	if ctx.Err() != nil {
+		return
+	}
+
+	source := rand.NewSource(time.Now().UnixNano())
+	tick := delaytick.New(source, period)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+		}
+
+		node, err := client.CoreV1().Nodes().Get(ctx, nodeID, metav1.GetOptions{})
+		if err != nil {
+			countError(err)
+			giveUp(fmt.Errorf("Failed to get node %s: %w", nodeID, err))
+			continue
+		}
+		if !resumed {
+			resumed = resume(node)
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+
+		var requests []*rebootrequest.NodeRebootRequest
+		if reboots != nil {
+			requests = reboots.due(ctx, node)
+		}
+
+		required := rebootRequired() || len(requests) > 0
+		reportRebootRequired(ctx, client, node, required)
+		if !required {
+			enterState(ctx, states, nodestate.Idle, "")
+			continue
+		}
+		if state := states.State(); state != nodestate.RebootRequired && state != nodestate.WaitingForLock {
+			enterState(ctx, states, nodestate.RebootRequired, "")
+		}
+
+		// The approval of the controller takes the place of the lock
+		if _, approved := node.Annotations[controller.RebootApprovedAnnotation]; !approved {
+			if reboots != nil {
+				reboots.track(ctx, requests, node, rebootrequest.Pending, "waiting for approval by the kured controller")
+			}
+			enterState(ctx, states, nodestate.WaitingForLock, "waiting for approval by the kured controller")
+			continue
+		}
+
+		if err := ns.update(node); err != nil {
+			log.Warnf("Ignoring invalid settings: %v", err)
+		}
+
+		log.Infof("Reboot of node %s approved", nodeID)
+		nodeMeta := nodeMeta{Unschedulable: node.Spec.Unschedulable, BootID: node.Status.NodeInfo.BootID}
+		if err := recordRebootInProgress(ctx, client, nodeID, &nodeMeta); err != nil {
+			log.Warnf("Error recording reboot: %v", err)
+			continue
+		}
+		if err := rebootNode(node, &nodeMeta, requests, true); err != nil {
+			abandonReboot(node, requests, err)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// recordRebootInProgress stores nodeMeta in the node, for use after the
+// reboot.
+func recordRebootInProgress(ctx context.Context, client kubernetes.Interface, nodeID string, nodeMeta *nodeMeta) error {
+	value, err := json.Marshal(nodeMeta)
+	if err != nil {
+		return err
+	}
+	s := string(value)
+	return controller.PatchNodeAnnotations(ctx, client, nodeID, map[string]*string{controller.RebootInProgressAnnotation: &s})
+}
@@ -0,0 +1,542 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+
+	"github.com/weaveworks/kured/pkg/alerts"
+	"github.com/weaveworks/kured/pkg/blockers"
+	"github.com/weaveworks/kured/pkg/ratelimit"
+	"github.com/weaveworks/kured/pkg/rollout"
+	"github.com/weaveworks/kured/pkg/timewindow"
+)
+
+// config is the content of the optional configuration file, which supplements
+// the command line flags. It is also the bulk of a RebootPolicy.
+type config struct {
+	Blockers []blockerConfig `json:"blockers,omitempty"`
+	Windows  []windowConfig  `json:"windows,omitempty"`
+	// Blackouts are dates or date ranges, as accepted by --blackout-dates.
+	Blackouts []string `json:"blackouts,omitempty"`
+	// Concurrency limits the number of nodes rebooting at the same time in
+	// controller mode.
+	Concurrency int              `json:"concurrency,omitempty"`
+	Drain       *drainConfig     `json:"drain,omitempty"`
+	Notifiers   *notifiersConfig `json:"notifiers,omitempty"`
+	Rollout     *rolloutConfig   `json:"rollout,omitempty"`
+}
+
+// drainConfig overrides the drain flags.
+type drainConfig struct {
+	Order              []string        `json:"order,omitempty"`
+	LastAnnotation     string          `json:"lastAnnotation,omitempty"`
+	LastPriority       *int32          `json:"lastPriority,omitempty"`
+	GracePeriodSeconds *int            `json:"gracePeriodSeconds,omitempty"`
+	Timeout            metav1.Duration `json:"timeout,omitempty"`
+}
+
+// notifiersConfig overrides the notification flags.
+type notifiersConfig struct {
+	SlackHookURL          string `json:"slackHookURL,omitempty"`
+	SlackUsername         string `json:"slackUsername,omitempty"`
+	SlackChannel          string `json:"slackChannel,omitempty"`
+	TeamsHookURL          string `json:"teamsHookURL,omitempty"`
+	MessageTemplateDrain  string `json:"messageTemplateDrain,omitempty"`
+	MessageTemplateReboot string `json:"messageTemplateReboot,omitempty"`
+	// MessageTemplateRolloutHalted overrides --message-template-rollout-halted.
+	MessageTemplateRolloutHalted string `json:"messageTemplateRolloutHalted,omitempty"`
+}
+
+// rolloutConfig divides the nodes into waves, rebooted one after the other.
+type rolloutConfig struct {
+	Waves []waveConfig `json:"waves"`
+	// HealthChecks are the names of blockers which must not block once a
+	// node is back from its reboot, besides the node being ready.
+	HealthChecks []string `json:"healthChecks,omitempty"`
+	// HealthTimeout is how long a node has to become healthy after its
+	// reboot, 10 minutes by default.
+	HealthTimeout metav1.Duration `json:"healthTimeout,omitempty"`
+}
+
+// waveConfig describes a wave of a rollout.
+type waveConfig struct {
+	Name string `json:"name"`
+	// NodeSelector is a label selector, as accepted by kubectl's --selector.
+	NodeSelector string          `json:"nodeSelector"`
+	Soak         metav1.Duration `json:"soak,omitempty"`
+}
+
+// windowConfig describes a reboot window, either by days and times of day or
+// by a cron expression and duration. Days default to every day and the time
+// zone to --time-zone.
+type windowConfig struct {
+	Days      []string `json:"days,omitempty"`
+	StartTime string   `json:"startTime,omitempty"`
+	EndTime   string   `json:"endTime,omitempty"`
+	TimeZone  string   `json:"timeZone,omitempty"`
+
+	Cron     string          `json:"cron,omitempty"`
+	Duration metav1.Duration `json:"duration,omitempty"`
+}
+
+// blockerConfig describes a single reboot blocker. Which of the fields apply
+// depends on the blocker type.
+type blockerConfig struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+
+	// type: prometheus, query
+	PrometheusURL string `json:"prometheusURL,omitempty"`
+
+	// type: alertmanager
+	AlertmanagerURL string `json:"alertmanagerURL,omitempty"`
+
+	// type: prometheus, alertmanager
+	AlertFilterRegexp    string   `json:"alertFilterRegexp,omitempty"`
+	AlertFilterMatchers  []string `json:"alertFilterMatchers,omitempty"`
+	AlertIncludeMatchers []string `json:"alertIncludeMatchers,omitempty"`
+	AlertFiringOnly      bool     `json:"alertFiringOnly,omitempty"`
+
+	// type: query
+	Query     string   `json:"query,omitempty"`
+	Threshold *float64 `json:"threshold,omitempty"`
+
+	// type: pods
+	PodSelectors       []string `json:"podSelectors,omitempty"`
+	Namespaces         []string `json:"namespaces,omitempty"`
+	AnnotationSelector string   `json:"annotationSelector,omitempty"`
+	OwnerKinds         []string `json:"ownerKinds,omitempty"`
+	IgnoreCompleted    bool     `json:"ignoreCompleted,omitempty"`
+
+	// type: webhook
+	URL            string          `json:"url,omitempty"`
+	Timeout        metav1.Duration `json:"timeout,omitempty"`
+	FailOpen       bool            `json:"failOpen,omitempty"`
+	CAFile         string          `json:"caFile,omitempty"`
+	ClientCertFile string          `json:"clientCertFile,omitempty"`
+	ClientKeyFile  string          `json:"clientKeyFile,omitempty"`
+
+	// type: clusterhealth
+	NodeSelector    string `json:"nodeSelector,omitempty"`
+	MinReadyPercent int    `json:"minReadyPercent,omitempty"`
+	BlockOnNotReady bool   `json:"blockOnNotReady,omitempty"`
+	BlockOnCordoned bool   `json:"blockOnCordoned,omitempty"`
+	BlockOnPressure bool   `json:"blockOnPressure,omitempty"`
+}
+
+// loadConfig reads the configuration file at path. An empty path yields an
+// empty configuration.
+func loadConfig(path string) (*config, error) {
+	cfg := &config{}
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("Error parsing %s: %v", path, err)
+	}
+
+	return cfg, nil
+}
+
+// newBlocker creates the blocker described by bc.
+func newBlocker(bc blockerConfig, client kubernetes.Interface, nodeID string, rebooting blockers.Rebooting) (blockers.Blocker, error) {
+	if bc.Name == "" {
+		return nil, fmt.Errorf("Blocker of type %s has no name", bc.Type)
+	}
+
+	switch bc.Type {
+	case "prometheus", "alertmanager":
+		var nameRegexp *regexp.Regexp
+		if bc.AlertFilterRegexp != "" {
+			var err error
+			if nameRegexp, err = regexp.Compile(bc.AlertFilterRegexp); err != nil {
+				return nil, fmt.Errorf("Blocker %s: %v", bc.Name, err)
+			}
+		}
+		filter, err := newAlertFilter(nameRegexp, bc.AlertIncludeMatchers, bc.AlertFilterMatchers, bc.AlertFiringOnly)
+		if err != nil {
+			return nil, fmt.Errorf("Blocker %s: %v", bc.Name, err)
+		}
+		if bc.Type == "alertmanager" {
+			url := bc.AlertmanagerURL
+			if url == "" {
+				url = alertmanagerURL
+			}
+			if url == "" {
+				return nil, fmt.Errorf("Blocker %s has no Alertmanager URL", bc.Name)
+			}
+			return blockers.NewAlertmanagerBlocker(bc.Name, url, filter), nil
+		}
+		url := bc.PrometheusURL
+		if url == "" {
+			url = prometheusURL
+		}
+		if url == "" {
+			return nil, fmt.Errorf("Blocker %s has no Prometheus URL", bc.Name)
+		}
+		return blockers.NewPrometheusBlocker(bc.Name, url, filter), nil
+	case "query":
+		if bc.Query == "" {
+			return nil, fmt.Errorf("Blocker %s has no query", bc.Name)
+		}
+		url := bc.PrometheusURL
+		if url == "" {
+			url = prometheusURL
+		}
+		if url == "" {
+			return nil, fmt.Errorf("Blocker %s has no Prometheus URL", bc.Name)
+		}
+		return blockers.NewQueryBlocker(bc.Name, url, bc.Query, bc.Threshold), nil
+	case "pods":
+		labelSelectors := bc.PodSelectors
+		if len(labelSelectors) == 0 {
+			labelSelectors = []string{""}
+		}
+		var selectors []blockers.PodSelector
+		for _, labelSelector := range labelSelectors {
+			selectors = append(selectors, blockers.PodSelector{
+				Namespaces:         bc.Namespaces,
+				LabelSelector:      labelSelector,
+				AnnotationSelector: bc.AnnotationSelector,
+				OwnerKinds:         bc.OwnerKinds,
+				IgnoreCompleted:    bc.IgnoreCompleted,
+			})
+		}
+		return blockers.NewPodBlocker(bc.Name, client, nodeID, selectors), nil
+	case "poddisruptionbudgets":
+		return blockers.NewPodDisruptionBudgetBlocker(bc.Name, client, nodeID), nil
+	case "webhook":
+		if bc.URL == "" {
+			return nil, fmt.Errorf("Blocker %s has no URL", bc.Name)
+		}
+		timeout := bc.Timeout.Duration
+		if timeout == 0 {
+			timeout = 10 * time.Second
+		}
+		blocker, err := blockers.NewWebhookBlocker(bc.Name, nodeID, blockers.WebhookOptions{
+			URL:         bc.URL,
+			Timeout:     timeout,
+			FailOpen:    bc.FailOpen,
+			ClusterName: clusterName,
+			Reasons:     rebootReasons(),
+			CAFile:      bc.CAFile,
+			CertFile:    bc.ClientCertFile,
+			KeyFile:     bc.ClientKeyFile,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Blocker %s: %v", bc.Name, err)
+		}
+		return blocker, nil
+	case "clusterhealth":
+		return blockers.NewClusterHealthBlocker(bc.Name, client, nodeID, rebooting, blockers.ClusterHealthOptions{
+			NodeSelector:    bc.NodeSelector,
+			MinReadyPercent: bc.MinReadyPercent,
+			BlockOnNotReady: bc.BlockOnNotReady,
+			BlockOnCordoned: bc.BlockOnCordoned,
+			BlockOnPressure: bc.BlockOnPressure,
+		}), nil
+	default:
+		return nil, fmt.Errorf("Blocker %s has unknown type: %q", bc.Name, bc.Type)
+	}
+}
+
+// newBlockerRegistry creates the registry of blockers configured via command
+// line flags, followed by those from the configuration file. rebooting tells
+// the nodes currently being rebooted, which cluster health checks disregard.
+func newBlockerRegistry(client kubernetes.Interface, nodeID string, rebooting blockers.Rebooting, configured []blockerConfig, plan *rollout.Plan) (*blockers.Registry, error) {
+	registry := blockers.NewRegistry()
+
+	if pauseAnnotation != "" {
+		if err := registry.Register(blockers.NewPauseBlocker("pause", client, nodeID, dsNamespace, dsName, pauseConfigMap, pauseAnnotation)); err != nil {
+			return nil, err
+		}
+	}
+
+	filter, err := newAlertFilter(alertFilter, alertIncludeMatchers, alertFilterMatchers, alertFiringOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	if prometheusURL != "" {
+		if err := registry.Register(blockers.NewPrometheusBlocker("prometheus", prometheusURL, filter)); err != nil {
+			return nil, err
+		}
+	}
+
+	if alertmanagerURL != "" {
+		if err := registry.Register(blockers.NewAlertmanagerBlocker("alertmanager", alertmanagerURL, filter)); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(podSelectors) > 0 {
+		var selectors []blockers.PodSelector
+		for _, labelSelector := range podSelectors {
+			selectors = append(selectors, blockers.PodSelector{
+				Namespaces:      podSelectorNamespaces,
+				LabelSelector:   labelSelector,
+				IgnoreCompleted: podSelectorIgnoreCompleted,
+			})
+		}
+		if err := registry.Register(blockers.NewPodBlocker("blocking-pod-selector", client, nodeID, selectors)); err != nil {
+			return nil, err
+		}
+	}
+
+	if blockOnPodDisruptionBudgets {
+		if err := registry.Register(blockers.NewPodDisruptionBudgetBlocker("pod-disruption-budgets", client, nodeID)); err != nil {
+			return nil, err
+		}
+	}
+
+	if clusterHealth.MinReadyPercent > 0 || clusterHealth.BlockOnNotReady || clusterHealth.BlockOnCordoned || clusterHealth.BlockOnPressure {
+		if err := registry.Register(blockers.NewClusterHealthBlocker("cluster-health", client, nodeID, rebooting, clusterHealth)); err != nil {
+			return nil, err
+		}
+	}
+
+	if blockingWebhook.URL != "" {
+		options := blockingWebhook
+		options.ClusterName = clusterName
+		options.Reasons = rebootReasons()
+		blocker, err := blockers.NewWebhookBlocker("webhook", nodeID, options)
+		if err != nil {
+			return nil, err
+		}
+		if err := registry.Register(blocker); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, q := range blockingQueries {
+		name, query, err := parseBlockingQuery(q)
+		if err != nil {
+			return nil, err
+		}
+		if prometheusURL == "" {
+			return nil, fmt.Errorf("Blocking query %s requires --prometheus-url", name)
+		}
+		if err := registry.Register(blockers.NewQueryBlocker(name, prometheusURL, query, nil)); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, bc := range configured {
+		blocker, err := newBlocker(bc, client, nodeID, rebooting)
+		if err != nil {
+			return nil, err
+		}
+		if err := registry.Register(blocker); err != nil {
+			return nil, err
+		}
+	}
+
+	if rateLimited() {
+		history := ratelimit.NewStore(client, dsNamespace, dsName, rebootHistoryAnnotation)
+		if err := registry.Register(blockers.NewRateLimitBlocker("rate-limit", history, rebootLimits())); err != nil {
+			return nil, err
+		}
+	}
+
+	if plan != nil {
+		if err := registry.Register(blockers.NewRolloutBlocker("rollout", client, nodeID, plan)); err != nil {
+			return nil, err
+		}
+	}
+
+	return registry, nil
+}
+
+// newRolloutPlan creates the rollout plan from the configuration, or returns
+// nil if none is configured.
+func newRolloutPlan(configured *rolloutConfig) (*rollout.Plan, error) {
+	if configured == nil {
+		return nil, nil
+	}
+	if len(configured.Waves) == 0 {
+		return nil, fmt.Errorf("Rollout without waves")
+	}
+	plan := &rollout.Plan{}
+	for _, wc := range configured.Waves {
+		if wc.Name == "" {
+			return nil, fmt.Errorf("Rollout wave without name")
+		}
+		wave, err := rollout.NewWave(wc.Name, wc.NodeSelector, wc.Soak.Duration)
+		if err != nil {
+			return nil, err
+		}
+		plan.Waves = append(plan.Waves, wave)
+	}
+	return plan, nil
+}
+
+// newAlertFilter creates an alert filter from an alert name regexp and sets
+// of label matchers in Prometheus selector syntax.
+func newAlertFilter(nameRegexp *regexp.Regexp, include, ignore []string, firingOnly bool) (*alerts.Filter, error) {
+	filter := &alerts.Filter{NameRegexp: nameRegexp, FiringOnly: firingOnly}
+	for _, s := range include {
+		ms, err := alerts.ParseMatchers(s)
+		if err != nil {
+			return nil, err
+		}
+		filter.Include = append(filter.Include, ms)
+	}
+	for _, s := range ignore {
+		ms, err := alerts.ParseMatchers(s)
+		if err != nil {
+			return nil, err
+		}
+		filter.Ignore = append(filter.Ignore, ms)
+	}
+	return filter, nil
+}
+
+// newRebootSchedule creates the schedule of reboot windows given via
+// --reboot-window, --reboot-window-cron and the configuration file. Without any such windows, the
+// schedule consists of the single window given by --reboot-days,
+// --start-time and --end-time.
+func newRebootSchedule(configured []windowConfig) (*timewindow.Schedule, error) {
+	var windows []timewindow.Window
+
+	for _, spec := range rebootWindows {
+		window, err := timewindow.Parse(spec, timezone)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, window)
+	}
+
+	for _, spec := range rebootCronWindows {
+		window, err := timewindow.ParseCron(spec, timezone)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, window)
+	}
+
+	for _, wc := range configured {
+		location := wc.TimeZone
+		if location == "" {
+			location = timezone
+		}
+
+		if wc.Cron != "" {
+			window, err := timewindow.NewCron(wc.Cron, wc.Duration.Duration, location)
+			if err != nil {
+				return nil, err
+			}
+			windows = append(windows, window)
+			continue
+		}
+
+		days := wc.Days
+		if len(days) == 0 {
+			days = timewindow.EveryDay
+		}
+		window, err := timewindow.New(days, wc.StartTime, wc.EndTime, location)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, window)
+	}
+
+	if len(windows) == 0 {
+		window, err := timewindow.New(rebootDays, rebootStart, rebootEnd, timezone)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, window)
+	}
+
+	return timewindow.NewSchedule(windows...), nil
+}
+
+// nodeRebootSchedule returns the reboot schedule of a node. If the node has
+// any of the reboot window annotations, they replace the windows of schedule,
+// with --reboot-days, --start-time, --end-time and --time-zone providing the
+// defaults for missing ones. Blackouts always apply.
+func nodeRebootSchedule(node *v1.Node, schedule *timewindow.Schedule) (*timewindow.Schedule, error) {
+	if rebootWindowAnnotationPrefix == "" {
+		return schedule, nil
+	}
+
+	days, start, end, location := rebootDays, rebootStart, rebootEnd, timezone
+	overridden := false
+	for suffix, value := range map[string]*string{
+		"start-time": &start,
+		"end-time":   &end,
+		"time-zone":  &location,
+	} {
+		if annotation, ok := node.Annotations[rebootWindowAnnotationPrefix+suffix]; ok {
+			*value = strings.TrimSpace(annotation)
+			overridden = true
+		}
+	}
+	if annotation, ok := node.Annotations[rebootWindowAnnotationPrefix+"days"]; ok {
+		days = strings.Split(strings.TrimSpace(annotation), ",")
+		overridden = true
+	}
+	if !overridden {
+		return schedule, nil
+	}
+
+	window, err := timewindow.New(days, start, end, location)
+	if err != nil {
+		return nil, err
+	}
+	return schedule.WithWindows(window), nil
+}
+
+// newBlackouts creates the blackouts given via --blackout-dates,
+// --blackout-calendar and the configuration file.
+func newBlackouts(configured []string) ([]timewindow.Blackout, error) {
+	var blackouts []timewindow.Blackout
+
+	for _, spec := range append(append([]string{}, blackoutDates...), configured...) {
+		blackout, err := timewindow.ParseBlackout(spec, timezone)
+		if err != nil {
+			return nil, err
+		}
+		blackouts = append(blackouts, blackout)
+	}
+
+	if blackoutCalendar != "" {
+		f, err := os.Open(blackoutCalendar)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		events, err := timewindow.ParseICalendar(f, timezone)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse calendar %s: %v", blackoutCalendar, err)
+		}
+		blackouts = append(blackouts, events...)
+	}
+
+	return blackouts, nil
+}
+
+// parseBlockingQuery splits a --blocking-query value of the form NAME=EXPR.
+func parseBlockingQuery(s string) (name, query string, err error) {
+	i := strings.Index(s, "=")
+	if i <= 0 || i == len(s)-1 {
+		return "", "", fmt.Errorf("Invalid blocking query, expected NAME=EXPR: %s", s)
+	}
+	return strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:]), nil
+}
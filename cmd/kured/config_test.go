@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/weaveworks/kured/pkg/timewindow"
+)
+
+func TestNewAlertBlocker(t *testing.T) {
+	prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[]}}`)
+	}))
+	defer prometheus.Close()
+	alertmanager := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/alerts" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `[]`)
+	}))
+	defer alertmanager.Close()
+
+	defer func(p, a string) {
+		prometheusURL, alertmanagerURL = p, a
+	}(prometheusURL, alertmanagerURL)
+
+	tests := []struct {
+		name            string
+		blocker         blockerConfig
+		prometheusURL   string
+		alertmanagerURL string
+		err             string
+	}{
+		{
+			name:    "prometheus with its own url",
+			blocker: blockerConfig{Name: "alerts", Type: "prometheus", PrometheusURL: prometheus.URL},
+		},
+		{
+			name:          "prometheus defaults to the flag",
+			blocker:       blockerConfig{Name: "alerts", Type: "prometheus"},
+			prometheusURL: prometheus.URL,
+		},
+		{
+			name:            "prometheus without url",
+			blocker:         blockerConfig{Name: "alerts", Type: "prometheus"},
+			alertmanagerURL: alertmanager.URL,
+			err:             "Blocker alerts has no Prometheus URL",
+		},
+		{
+			name:    "alertmanager with its own url",
+			blocker: blockerConfig{Name: "alerts", Type: "alertmanager", AlertmanagerURL: alertmanager.URL},
+		},
+		{
+			name:            "alertmanager defaults to the flag",
+			blocker:         blockerConfig{Name: "alerts", Type: "alertmanager"},
+			alertmanagerURL: alertmanager.URL,
+		},
+		{
+			name:          "alertmanager without url",
+			blocker:       blockerConfig{Name: "alerts", Type: "alertmanager"},
+			prometheusURL: prometheus.URL,
+			err:           "Blocker alerts has no Alertmanager URL",
+		},
+	}
+
+	for _, tst := range tests {
+		prometheusURL, alertmanagerURL = tst.prometheusURL, tst.alertmanagerURL
+		blocker, err := newBlocker(tst.blocker, nil, "node-1", nil)
+		if tst.err != "" {
+			if err == nil || err.Error() != tst.err {
+				t.Errorf("Test %s: Expected error %q got %v", tst.name, tst.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %s: Unexpected error: %v", tst.name, err)
+			continue
+		}
+		// Blockers which cannot reach their server block
+		if blocked, reason := blocker.IsBlocked(context.Background()); blocked {
+			t.Errorf("Test %s: Expected no block got %s", tst.name, reason)
+		}
+	}
+}
+
+// setScheduleFlags sets the reboot window flags to their defaults, with the
+// given windows and blackouts, and returns a function restoring them.
+func setScheduleFlags(windows, blackouts []string) func() {
+	days, start, end, location := rebootDays, rebootStart, rebootEnd, timezone
+	specs, cronSpecs, dates, prefix := rebootWindows, rebootCronWindows, blackoutDates, rebootWindowAnnotationPrefix
+	rebootDays, rebootStart, rebootEnd, timezone = timewindow.EveryDay, "0:00", "23:59:59", "UTC"
+	rebootWindows, rebootCronWindows, blackoutDates, rebootWindowAnnotationPrefix = windows, nil, blackouts, "kured/"
+	return func() {
+		rebootDays, rebootStart, rebootEnd, timezone = days, start, end, location
+		rebootWindows, rebootCronWindows, blackoutDates, rebootWindowAnnotationPrefix = specs, cronSpecs, dates, prefix
+	}
+}
+
+func TestNodeRebootSchedule(t *testing.T) {
+	defer setScheduleFlags([]string{"sat,sun 0:00 6:00"}, []string{"2019-04-08"})()
+
+	// 2019-04-01 is a monday
+	configured := []windowConfig{{Days: []string{"mon"}, StartTime: "2:00", EndTime: "4:00"}}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		contains    map[string]bool
+		err         bool
+	}{
+		{
+			name: "configured windows",
+			contains: map[string]bool{
+				"2019-04-01T03:00:00Z": true,
+				"2019-04-01T05:00:00Z": false,
+				"2019-04-02T03:00:00Z": false,
+				"2019-04-06T03:00:00Z": true,
+				"2019-04-08T03:00:00Z": false,
+			},
+		},
+		{
+			name:        "annotations replace the windows",
+			annotations: map[string]string{"kured/start-time": "22:00", "kured/end-time": "23:00"},
+			contains: map[string]bool{
+				"2019-04-01T03:00:00Z": false,
+				"2019-04-06T03:00:00Z": false,
+				"2019-04-02T22:30:00Z": true,
+				"2019-04-08T22:30:00Z": false,
+			},
+		},
+		{
+			name:        "days annotation",
+			annotations: map[string]string{"kured/days": "tue,wed"},
+			contains: map[string]bool{
+				"2019-04-01T03:00:00Z": false,
+				"2019-04-02T12:00:00Z": true,
+				"2019-04-03T23:00:00Z": true,
+			},
+		},
+		{
+			name:        "time zone annotation",
+			annotations: map[string]string{"kured/start-time": "22:00", "kured/end-time": "23:00", "kured/time-zone": "Europe/Berlin"},
+			contains: map[string]bool{
+				"2019-04-02T20:30:00Z": true,
+				"2019-04-02T22:30:00Z": false,
+			},
+		},
+		{
+			name:        "unrelated annotation",
+			annotations: map[string]string{"kured/reboot": "true"},
+			contains: map[string]bool{
+				"2019-04-01T03:00:00Z": true,
+				"2019-04-02T03:00:00Z": false,
+			},
+		},
+		{
+			name:        "invalid time",
+			annotations: map[string]string{"kured/start-time": "25:00"},
+			err:         true,
+		},
+		{
+			name:        "invalid time zone",
+			annotations: map[string]string{"kured/time-zone": "Mars/Olympus_Mons"},
+			err:         true,
+		},
+	}
+
+	for _, tst := range tests {
+		schedule, err := newRebootSchedule(configured)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		blackouts, err := newBlackouts(nil)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		schedule.Exclude(blackouts...)
+
+		node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Annotations: tst.annotations}}
+		nodeSchedule, err := nodeRebootSchedule(node, schedule)
+		if tst.err {
+			if err == nil {
+				t.Errorf("Test %s: Expected error", tst.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %s: Unexpected error: %v", tst.name, err)
+			continue
+		}
+		checkSchedule(t, tst.name, nodeSchedule, tst.contains)
+	}
+}
+
+func TestNewRebootSchedule(t *testing.T) {
+	// 2019-04-01 is a monday
+	tests := []struct {
+		name       string
+		windows    []string
+		configured []windowConfig
+		contains   map[string]bool
+		err        bool
+	}{
+		{
+			name: "default window",
+			contains: map[string]bool{
+				"2019-04-01T03:00:00Z": true,
+				"2019-04-06T12:00:00Z": true,
+			},
+		},
+		{
+			name:       "flags and configuration combined",
+			windows:    []string{"sat,sun 0:00 6:00"},
+			configured: []windowConfig{{Days: []string{"mon"}, StartTime: "2:00", EndTime: "4:00"}},
+			contains: map[string]bool{
+				"2019-04-01T03:00:00Z": true,
+				"2019-04-06T03:00:00Z": true,
+				"2019-04-02T03:00:00Z": false,
+				"2019-04-06T12:00:00Z": false,
+			},
+		},
+		{
+			name:       "configured time zone and cron",
+			configured: []windowConfig{{StartTime: "2:00", EndTime: "4:00", TimeZone: "Europe/Berlin"}, {Cron: "0 12 * * tue", Duration: metav1.Duration{Duration: time.Hour}}},
+			contains: map[string]bool{
+				"2019-04-01T00:30:00Z": true,
+				"2019-04-01T03:00:00Z": false,
+				"2019-04-02T12:30:00Z": true,
+				"2019-04-03T12:30:00Z": false,
+			},
+		},
+		{
+			name:    "invalid window flag",
+			windows: []string{"someday 0:00 6:00"},
+			err:     true,
+		},
+		{
+			name:       "invalid configured window",
+			configured: []windowConfig{{StartTime: "2:00", EndTime: "4:00", TimeZone: "Mars/Olympus_Mons"}},
+			err:        true,
+		},
+	}
+
+	for _, tst := range tests {
+		restore := setScheduleFlags(tst.windows, nil)
+		schedule, err := newRebootSchedule(tst.configured)
+		restore()
+		if tst.err {
+			if err == nil {
+				t.Errorf("Test %s: Expected error", tst.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %s: Unexpected error: %v", tst.name, err)
+			continue
+		}
+		checkSchedule(t, tst.name, schedule, tst.contains)
+	}
+}
+
+// checkSchedule checks whether schedule contains each of the given times.
+func checkSchedule(t *testing.T, name string, schedule *timewindow.Schedule, contains map[string]bool) {
+	for at, expected := range contains {
+		tm, err := time.Parse(time.RFC3339, at)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if schedule.Contains(tm) != expected {
+			t.Errorf("Test %s: Expected %v to contain %s: %v", name, schedule, at, expected)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+
+	"github.com/weaveworks/kured/pkg/controller"
+	"github.com/weaveworks/kured/pkg/ratelimit"
+	"github.com/weaveworks/kured/pkg/timewindow"
+)
+
+var (
+	concurrency     int
+	strategyName    string
+	reconcilePeriod time.Duration
+	leaseName       string
+)
+
+// newControllerCommand creates the controller subcommand, which decides
+// centrally which nodes to reboot when kured runs with --agent.
+func newControllerCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "controller",
+		Short: "Orchestrate the reboots of nodes running kured with --agent",
+		Long: "Approve the reboots of nodes running kured with --agent, subject to the reboot windows and blockers, " +
+			"leaving the drain and reboot to the agents. Runs as a leader-elected Deployment.",
+		Args: cobra.NoArgs,
+		Run:  runController}
+
+	cmd.Flags().IntVar(&concurrency, "concurrency", 1,
+		"maximum number of nodes rebooting at the same time")
+	cmd.Flags().StringVar(&strategyName, "strategy", "oldest-first",
+		"order in which to reboot nodes: oldest-first (longest waiting first), by-name or random")
+	cmd.Flags().DurationVar(&reconcilePeriod, "reconcile-period", time.Minute,
+		"how often to look for nodes to reboot")
+	cmd.Flags().StringVar(&leaseName, "leader-election-lease", "kured-controller",
+		"name of the lease in --ds-namespace used for leader election")
+
+	return cmd
+}
+
+func runController(cmd *cobra.Command, args []string) {
+	log.Infof("Kubernetes Reboot Daemon Controller: %s", version)
+
+	identity, err := os.Hostname()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if concurrency < 1 {
+		log.Fatalf("Invalid concurrency: %d", concurrency)
+	}
+	strategy, err := controller.NewStrategy(strategyName)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cfg, err := loadConfig(configFile)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	defaults, err := newSettings(cfg, "configuration")
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Fail early on invalid blocker configuration
+	registry, err := newBlockerRegistry(client, "", rebootingApproved, cfg.Blockers, defaults.rollout)
+	if err != nil {
+		log.Fatalf("Failed to configure reboot blockers: %v", err)
+	}
+
+	log.Infof("Identity: %s", identity)
+	log.Infof("Leader Election Lease: %s/%s", dsNamespace, leaseName)
+	log.Infof("Concurrency: %d", concurrency)
+	log.Infof("Strategy: %s", strategy.Name())
+	log.Infof("Reboot on: %v", defaults.schedule)
+	log.Infof("Reboot blockers: %v", registry.Names())
+
+	var policies *policyWatcher
+	if watchRebootPolicies {
+		log.Infof("Watching RebootPolicies")
+		policies = watchPolicies()
+	}
+
+	// Leadership is released on shutdown, for another controller to take over
+	ctx := shutdownContext()
+	ctrl := controller.New(client, strategy, concurrency, newNodeEligibility(client, cfg, policies, defaults.notifiers).reconcile)
+
+	go func() {
+		http.Handle("/metrics", promhttp.Handler())
+		log.Fatal(http.ListenAndServe(":8080", nil))
+	}()
+
+	leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+		Lock: &resourcelock.LeaseLock{
+			LeaseMeta:  metav1.ObjectMeta{Namespace: dsNamespace, Name: leaseName},
+			Client:     client.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+		},
+		ReleaseOnCancel: true,
+		LeaseDuration:   15 * time.Second,
+		RenewDeadline:   10 * time.Second,
+		RetryPeriod:     2 * time.Second,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				log.Infof("Started leading")
+				wait.Until(func() {
+					reconcile(ctx, ctrl)
+					if policies != nil {
+						updatePolicyStatuses(ctx, client, policies, rebootApproved)
+					}
+				}, reconcilePeriod, ctx.Done())
+			},
+			OnStoppedLeading: func() {
+				if ctx.Err() != nil {
+					log.Infof("Stopped leading, shutting down")
+					return
+				}
+				log.Fatalf("Stopped leading")
+			},
+			OnNewLeader: func(leader string) {
+				if leader != identity {
+					log.Infof("Leader is %s", leader)
+				}
+			},
+		},
+	})
+}
+
+// reconcile runs a single reconciliation, publishing the resulting status.
+func reconcile(ctx context.Context, ctrl *controller.Controller) {
+	status, err := ctrl.Reconcile(ctx)
+	if err != nil {
+		log.Warnf("Error reconciling nodes: %v", err)
+		return
+	}
+	controllerNodesGauge.WithLabelValues("pending").Set(float64(len(status.Pending)))
+	controllerNodesGauge.WithLabelValues("in_progress").Set(float64(len(status.InProgress)))
+	if len(status.Pending) > 0 || len(status.InProgress) > 0 {
+		log.Infof("Nodes pending reboot: %v, rebooting: %v", status.Pending, status.InProgress)
+	}
+}
+
+// nodeEligibility determines which nodes may be rebooted now, based on their
+// reboot window, the blockers and the concurrency of the RebootPolicy
+// applying to them. The settings and blockers of every node are kept across
+// reconciliations, and only rebuilt once the policy applying to it changes.
+type nodeEligibility struct {
+	client   kubernetes.Interface
+	cfg      *config
+	policies *policyWatcher
+	nodes    map[string]*nodeSettings
+	// history is nil unless rate limits are configured.
+	history   *ratelimit.Store
+	notifiers notifiers
+}
+
+func newNodeEligibility(client kubernetes.Interface, cfg *config, policies *policyWatcher, n notifiers) *nodeEligibility {
+	return &nodeEligibility{client: client, cfg: cfg, policies: policies, nodes: map[string]*nodeSettings{}, history: newRebootHistory(client), notifiers: n}
+}
+
+// reconcile starts a reconciliation of nodes, counting the reboots already
+// approved under every policy, once expired approvals are withdrawn.
+func (ne *nodeEligibility) reconcile(ctx context.Context, nodes []v1.Node) controller.Eligibility {
+	pass := &eligibilityPass{nodeEligibility: ne, ctx: ctx, rebooting: map[string]int{}, expired: map[string]bool{}}
+	if ne.policies != nil {
+		pass.all = ne.policies.policies()
+	}
+
+	seen := make(map[string]bool, len(nodes))
+	for i := range nodes {
+		node := &nodes[i]
+		seen[node.Name] = true
+		if !rebootApproved(node) {
+			continue
+		}
+		if ne.expireApproval(ctx, node) {
+			pass.expired[node.Name] = true
+		} else {
+			pass.rebooting[pass.policyName(node)]++
+		}
+	}
+	for name := range ne.nodes {
+		if !seen[name] {
+			delete(ne.nodes, name)
+		}
+	}
+	return pass
+}
+
+// expireApproval withdraws the approval of a node older than the lock TTL,
+// unless the node rebooted meanwhile, and returns whether it did. Like the
+// lock, the approval would otherwise be kept forever by an agent which never
+// completes the reboot. If the agent started the reboot, it failed.
+func (ne *nodeEligibility) expireApproval(ctx context.Context, node *v1.Node) bool {
+	if lockTTL <= 0 {
+		return false
+	}
+	approved, err := time.Parse(time.RFC3339, node.Annotations[controller.RebootApprovedAnnotation])
+	if err != nil || time.Since(approved) < lockTTL {
+		return false
+	}
+	meta := &nodeMeta{}
+	value, inProgress := node.Annotations[controller.RebootInProgressAnnotation]
+	if inProgress {
+		if err := json.Unmarshal([]byte(value), meta); err != nil {
+			log.Warnf("Invalid %s annotation on node %s: %v", controller.RebootInProgressAnnotation, node.Name, err)
+			return false
+		}
+		if meta.rebooted(node) {
+			return false
+		}
+	}
+
+	log.Warnf("Withdrawing reboot approval of node %s, which expired after %v", node.Name, lockTTL)
+	if err := controller.PatchNodeAnnotations(ctx, ne.client, node.Name, map[string]*string{
+		controller.RebootApprovedAnnotation:   nil,
+		controller.RebootInProgressAnnotation: nil,
+	}); err != nil {
+		log.Warnf("Error withdrawing reboot approval of node %s: %v", node.Name, err)
+		return false
+	}
+	if inProgress {
+		recordStaleReboot(ctx, ne.history, node, meta, "its approval expired", ne.notifiers)
+	}
+	return true
+}
+
+// eligibilityPass determines the eligibility of nodes during a single
+// reconciliation.
+type eligibilityPass struct {
+	*nodeEligibility
+	ctx context.Context
+	all []*rebootPolicy
+	// rebooting counts the nodes whose reboot is approved by the name of
+	// the policy applying to them, empty for none.
+	rebooting map[string]int
+	// expired are the nodes whose approval was withdrawn as expired.
+	expired map[string]bool
+}
+
+// policyName returns the name of the policy applying to the node, or the
+// empty string if none does.
+func (p *eligibilityPass) policyName(node *v1.Node) string {
+	if policy := matchPolicy(p.all, node); policy != nil {
+		return policy.Name
+	}
+	return ""
+}
+
+func (p *eligibilityPass) Eligible(node *v1.Node) (bool, string) {
+	ns, ok := p.nodes[node.Name]
+	if !ok {
+		ns = &nodeSettings{client: p.client, nodeID: node.Name, rebooting: rebootingApproved, cfg: p.cfg, policies: p.policies}
+		p.nodes[node.Name] = ns
+	}
+	if err := ns.update(node); err != nil {
+		return false, err.Error()
+	}
+	st := ns.settings
+
+	nodeSchedule, err := nodeRebootSchedule(node, st.schedule)
+	if err != nil {
+		log.Warnf("Ignoring invalid reboot window annotations on node %s: %v", node.Name, err)
+		nodeSchedule = st.schedule
+	}
+
+	now := time.Now()
+	if !nodeSchedule.Contains(now) {
+		return false, fmt.Sprintf("outside of reboot window %v", nodeSchedule)
+	}
+	if end, closes := timewindow.End(nodeSchedule, now, windowEndHorizon); closes && end.Sub(now) < minWindowRemaining {
+		return false, fmt.Sprintf("reboot window closes at %v, less than %v from now", end, minWindowRemaining)
+	}
+
+	if st.concurrency > 0 {
+		if rebooting := p.rebooting[p.policyName(node)]; rebooting >= st.concurrency {
+			return false, fmt.Sprintf("%d nodes using the %s already rebooting", rebooting, st.source)
+		}
+	}
+
+	if blocked, reason := rebootBlocked(p.ctx, ns.registry, node.Name); blocked {
+		return false, reason
+	}
+
+	return true, ""
+}
+
+// Approved counts the reboot against the concurrency of the policy, and
+// records it in the history right away, for the rate limits to hold for the
+// nodes considered next.
+func (p *eligibilityPass) Approved(node *v1.Node) {
+	p.rebooting[p.policyName(node)]++
+	recordRebootStarted(p.ctx, p.history, node.Name)
+}
+
+func (p *eligibilityPass) Expired(node *v1.Node) bool {
+	return p.expired[node.Name]
+}
+
+// rebootApproved determines whether the controller approved the reboot of a
+// node.
+func rebootApproved(node *v1.Node) bool {
+	_, approved := node.Annotations[controller.RebootApprovedAnnotation]
+	return approved
+}
+
+// rebootingApproved is used in place of the lock holder in controller mode,
+// which doesn't use the lock: nodes whose reboot the controller approved,
+// or which are still rebooting, are expected to be cordoned.
+func rebootingApproved(ctx context.Context) (func(node *v1.Node) bool, error) {
+	return func(node *v1.Node) bool {
+		_, inProgress := node.Annotations[controller.RebootInProgressAnnotation]
+		return inProgress || rebootApproved(node)
+	}, nil
+}
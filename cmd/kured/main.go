@@ -2,18 +2,24 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/rand"
 	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
 	"regexp"
+	"strings"
+	"syscall"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	kubectldrain "k8s.io/kubectl/pkg/drain"
@@ -21,10 +27,15 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/weaveworks/kured/pkg/alerts"
+	"github.com/weaveworks/kured/pkg/blockers"
+	"github.com/weaveworks/kured/pkg/controller"
 	"github.com/weaveworks/kured/pkg/daemonsetlock"
 	"github.com/weaveworks/kured/pkg/delaytick"
+	"github.com/weaveworks/kured/pkg/errorclass"
+	"github.com/weaveworks/kured/pkg/nodestate"
 	"github.com/weaveworks/kured/pkg/notifications/slack"
 	"github.com/weaveworks/kured/pkg/notifications/teams"
+	"github.com/weaveworks/kured/pkg/rebootrequest"
 	"github.com/weaveworks/kured/pkg/taints"
 	"github.com/weaveworks/kured/pkg/timewindow"
 )
@@ -33,27 +44,63 @@ var (
 	version = "unreleased"
 
 	// Command line flags
-	period                    time.Duration
-	dsNamespace               string
-	dsName                    string
-	lockAnnotation            string
-	lockTTL                   time.Duration
-	prometheusURL             string
-	alertFilter               *regexp.Regexp
-	rebootSentinel            string
-	preferNoScheduleTaintName string
-	slackHookURL              string
-	slackUsername             string
-	slackChannel              string
-	teamsHookURL              string
-	messageTemplateDrain      string
-	messageTemplateReboot     string
-	podSelectors              []string
-
-	rebootDays  []string
-	rebootStart string
-	rebootEnd   string
-	timezone    string
+	configFile                    string
+	period                        time.Duration
+	dsNamespace                   string
+	dsName                        string
+	lockAnnotation                string
+	lockTTL                       time.Duration
+	pauseAnnotation               string
+	pauseConfigMap                string
+	prometheusURL                 string
+	alertmanagerURL               string
+	alertFilter                   *regexp.Regexp
+	alertFilterMatchers           []string
+	alertIncludeMatchers          []string
+	alertFiringOnly               bool
+	blockingQueries               []string
+	rebootSentinel                string
+	preferNoScheduleTaintName     string
+	slackHookURL                  string
+	slackUsername                 string
+	slackChannel                  string
+	teamsHookURL                  string
+	messageTemplateDrain          string
+	messageTemplateReboot         string
+	messageTemplateRolloutHalted  string
+	messageTemplateCircuitBreaker string
+	maxRebootsPerHour             int
+	maxRebootsPerDay              int
+	maxConsecutiveFailures        int
+	rebootHistoryAnnotation       string
+	silenceAlertmanagerURL        string
+	silenceLabels                 []string
+	silenceDuration               time.Duration
+	podSelectors                  []string
+	podSelectorNamespaces         []string
+	podSelectorIgnoreCompleted    bool
+	blockOnPodDisruptionBudgets   bool
+	clusterHealth                 blockers.ClusterHealthOptions
+	blockingWebhook               blockers.WebhookOptions
+	clusterName                   string
+	drainOrder                    []string
+	drainLastAnnotation           string
+	drainLastPriority             int32
+
+	rebootDays                   []string
+	rebootStart                  string
+	rebootEnd                    string
+	timezone                     string
+	rebootWindows                []string
+	rebootCronWindows            []string
+	blackoutDates                []string
+	rebootWindowAnnotationPrefix string
+	agent                        bool
+	minWindowRemaining           time.Duration
+	abortDrainAtWindowEnd        bool
+	blackoutCalendar             string
+	watchRebootPolicies          bool
+	watchRebootRequests          bool
 
 	// Metrics
 	rebootRequiredGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
@@ -61,10 +108,46 @@ var (
 		Name:      "reboot_required",
 		Help:      "OS requires reboot due to software updates.",
 	}, []string{"node"})
+	rebootBlockedGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem: "kured",
+		Name:      "reboot_blocked",
+		Help:      "Reboot is prevented by the named blocker.",
+	}, []string{"node", "blocker"})
+	nextWindowGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem: "kured",
+		Name:      "reboot_window_next_seconds",
+		Help:      "Seconds until the reboot window next opens, 0 while it is open.",
+	}, []string{"node"})
+	controllerNodesGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem: "kured",
+		Name:      "controller_nodes",
+		Help:      "Number of nodes pending or in progress of a reboot approved by the controller.",
+	}, []string{"state"})
+	recentRebootsGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem: "kured",
+		Name:      "recent_reboots",
+		Help:      "Number of reboots started across the cluster in the last hour or day.",
+	}, []string{"period"})
+	circuitBreakerGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Subsystem: "kured",
+		Name:      "circuit_breaker_tripped",
+		Help:      "Reboots are stopped after too many failed reboots in a row.",
+	})
+	errorsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: "kured",
+		Name:      "errors_total",
+		Help:      "Number of errors of API operations, by class: transient, conflict, stale or fatal.",
+	}, []string{"class"})
 )
 
 func init() {
 	prometheus.MustRegister(rebootRequiredGauge)
+	prometheus.MustRegister(rebootBlockedGauge)
+	prometheus.MustRegister(nextWindowGauge)
+	prometheus.MustRegister(controllerNodesGauge)
+	prometheus.MustRegister(recentRebootsGauge)
+	prometheus.MustRegister(circuitBreakerGauge)
+	prometheus.MustRegister(errorsCounter)
 }
 
 func main() {
@@ -72,7 +155,14 @@ func main() {
 		Use:   "kured",
 		Short: "Kubernetes Reboot Daemon",
 		Run:   root}
+	rootCmd.AddCommand(newScheduleCommand())
+	rootCmd.AddCommand(newResetCircuitBreakerCommand())
+	rootCmd.AddCommand(newControllerCommand())
 
+	rootCmd.Flags().BoolVar(&agent, "agent", false,
+		"only report whether the node requires a reboot and reboot it once approved by \"kured controller\"")
+	rootCmd.PersistentFlags().StringVar(&configFile, "config", "",
+		"path to a YAML file with additional configuration, such as reboot blockers")
 	rootCmd.PersistentFlags().DurationVar(&period, "period", time.Minute*60,
 		"reboot check period")
 	rootCmd.PersistentFlags().StringVar(&dsNamespace, "ds-namespace", "kube-system",
@@ -83,10 +173,48 @@ func main() {
 		"annotation in which to record locking node")
 	rootCmd.PersistentFlags().DurationVar(&lockTTL, "lock-ttl", 0,
 		"expire lock annotation after this duration (default: 0, disabled)")
+	rootCmd.PersistentFlags().StringVar(&pauseAnnotation, "pause-annotation", "weave.works/kured-paused",
+		"annotation on the daemonset, pause configmap or a node which pauses reboots cluster-wide or for that node (set to empty to disable)")
+	rootCmd.PersistentFlags().StringVar(&pauseConfigMap, "pause-configmap", "",
+		"name of a configmap in the daemonset namespace whose pause annotation pauses reboots cluster-wide")
 	rootCmd.PersistentFlags().StringVar(&prometheusURL, "prometheus-url", "",
 		"Prometheus instance to probe for active alerts")
+	rootCmd.PersistentFlags().StringVar(&alertmanagerURL, "alertmanager-url", "",
+		"Alertmanager instance to probe for active alerts which are neither silenced nor inhibited")
 	rootCmd.PersistentFlags().Var(&regexpValue{&alertFilter}, "alert-filter-regexp",
 		"alert names to ignore when checking for active alerts")
+	rootCmd.PersistentFlags().StringArrayVar(&alertFilterMatchers, "alert-filter-matchers", nil,
+		"label matchers (e.g. 'severity=\"info\",namespace=~\"dev-.*\"') identifying alerts to ignore when checking for active alerts")
+	rootCmd.PersistentFlags().StringArrayVar(&alertIncludeMatchers, "alert-include-matchers", nil,
+		"label matchers (e.g. 'severity=~\"critical|page\"') identifying the only alerts to consider when checking for active alerts")
+	rootCmd.PersistentFlags().BoolVar(&alertFiringOnly, "alert-firing-only", false,
+		"only consider firing alerts, not pending ones, when checking for active alerts")
+	rootCmd.PersistentFlags().StringVar(&clusterHealth.NodeSelector, "cluster-health-node-selector", "",
+		"label selector restricting the nodes considered by the cluster health checks")
+	rootCmd.PersistentFlags().IntVar(&clusterHealth.MinReadyPercent, "cluster-health-min-ready-percent", 0,
+		"prevent reboots while less than this percentage of nodes are ready (default: 0, disabled)")
+	rootCmd.PersistentFlags().BoolVar(&clusterHealth.BlockOnNotReady, "cluster-health-block-not-ready", false,
+		"prevent reboots while any other node is not ready")
+	rootCmd.PersistentFlags().BoolVar(&clusterHealth.BlockOnCordoned, "cluster-health-block-cordoned", false,
+		"prevent reboots while any other node is cordoned, other than by kured")
+	rootCmd.PersistentFlags().BoolVar(&clusterHealth.BlockOnPressure, "cluster-health-block-pressure", false,
+		"prevent reboots while any other node reports memory, disk or PID pressure")
+	rootCmd.PersistentFlags().StringVar(&blockingWebhook.URL, "blocking-webhook-url", "",
+		"endpoint asked whether a reboot may proceed, e.g. by a change-management system")
+	rootCmd.PersistentFlags().DurationVar(&blockingWebhook.Timeout, "blocking-webhook-timeout", 10*time.Second,
+		"timeout for requests to --blocking-webhook-url")
+	rootCmd.PersistentFlags().BoolVar(&blockingWebhook.FailOpen, "blocking-webhook-fail-open", false,
+		"allow reboots if --blocking-webhook-url cannot be reached or fails")
+	rootCmd.PersistentFlags().StringVar(&blockingWebhook.CAFile, "blocking-webhook-ca-file", "",
+		"CA certificates used to verify --blocking-webhook-url (default: system roots)")
+	rootCmd.PersistentFlags().StringVar(&blockingWebhook.CertFile, "blocking-webhook-client-cert", "",
+		"client certificate presented to --blocking-webhook-url")
+	rootCmd.PersistentFlags().StringVar(&blockingWebhook.KeyFile, "blocking-webhook-client-key", "",
+		"client certificate key for --blocking-webhook-client-cert")
+	rootCmd.PersistentFlags().StringVar(&clusterName, "cluster-name", "",
+		"name of the cluster, passed on to --blocking-webhook-url")
+	rootCmd.PersistentFlags().StringArrayVar(&blockingQueries, "blocking-query", nil,
+		"NAME=EXPR PromQL expression evaluated against --prometheus-url whose non-empty result should prevent reboots")
 	rootCmd.PersistentFlags().StringVar(&rebootSentinel, "reboot-sentinel", "/var/run/reboot-required",
 		"path to file whose existence signals need to reboot")
 	rootCmd.PersistentFlags().StringVar(&preferNoScheduleTaintName, "prefer-no-schedule-taint", "",
@@ -104,9 +232,33 @@ func main() {
 		"message template used to notify about a node being drained")
 	rootCmd.PersistentFlags().StringVar(&messageTemplateReboot, "message-template-reboot", "Rebooting node %s",
 		"message template used to notify about a node being rebooted")
+	rootCmd.PersistentFlags().StringVar(&messageTemplateRolloutHalted, "message-template-rollout-halted", "Reboot rollout halted, node %s is unhealthy after its reboot: %s",
+		"message template used to notify about a node being unhealthy after its reboot, given the node and the reason")
+	rootCmd.PersistentFlags().StringVar(&messageTemplateCircuitBreaker, "message-template-circuit-breaker", "Reboots stopped by circuit breaker: %s",
+		"message template used to notify about the circuit breaker stopping all reboots, given the reason")
+
+	rootCmd.PersistentFlags().StringVar(&silenceAlertmanagerURL, "silence-alertmanager-url", "",
+		"Alertmanager instance in which to silence alerts about a node while it reboots")
+	rootCmd.PersistentFlags().StringSliceVar(&silenceLabels, "silence-labels", []string{"node", "instance"},
+		"alert labels identifying the rebooting node; one silence is created per label")
+	rootCmd.PersistentFlags().DurationVar(&silenceDuration, "silence-duration", time.Hour,
+		"maximum duration of alert silences, in case they are not expired after the reboot")
 
 	rootCmd.PersistentFlags().StringArrayVar(&podSelectors, "blocking-pod-selector", nil,
 		"label selector identifying pods whose presence should prevent reboots")
+	rootCmd.PersistentFlags().StringSliceVar(&podSelectorNamespaces, "blocking-pod-namespaces", nil,
+		"only consider pods in these namespaces for --blocking-pod-selector (default: all namespaces)")
+	rootCmd.PersistentFlags().BoolVar(&podSelectorIgnoreCompleted, "blocking-pod-ignore-completed", false,
+		"ignore pods which have succeeded or failed for --blocking-pod-selector")
+	rootCmd.PersistentFlags().BoolVar(&blockOnPodDisruptionBudgets, "blocking-pod-disruption-budgets", false,
+		"prevent reboots while a pod disruption budget covering pods on the node allows no disruptions")
+
+	rootCmd.PersistentFlags().StringSliceVar(&drainOrder, "drain-order", nil,
+		"evict pods in these tiers one after another, waiting for each to finish (stateless, stateful, last); unlisted tiers are evicted at the end (default: all at once)")
+	rootCmd.PersistentFlags().StringVar(&drainLastAnnotation, "drain-last-annotation", "weave.works/kured-drain-last",
+		"pod annotation which, when set to \"true\", places a pod into the last drain tier")
+	rootCmd.PersistentFlags().Int32Var(&drainLastPriority, "drain-last-priority", 0,
+		"pods with at least this priority are placed into the last drain tier (default: 0, disabled)")
 
 	rootCmd.PersistentFlags().StringSliceVar(&rebootDays, "reboot-days", timewindow.EveryDay,
 		"schedule reboot on these days")
@@ -116,6 +268,32 @@ func main() {
 		"schedule reboot only before this time of day")
 	rootCmd.PersistentFlags().StringVar(&timezone, "time-zone", "UTC",
 		"use this timezone for schedule inputs")
+	rootCmd.PersistentFlags().StringArrayVar(&rebootWindows, "reboot-window", nil,
+		"schedule reboot during this window, given as \"DAYS START END [TIMEZONE]\", e.g. \"mo,tu,we,th,fr 02:00 05:00 Europe/Berlin\"; may be repeated, replaces --reboot-days, --start-time and --end-time")
+	rootCmd.PersistentFlags().StringArrayVar(&rebootCronWindows, "reboot-window-cron", nil,
+		"schedule reboot during this window, given as \"[CRON_TZ=TIMEZONE] MIN HOUR DOM MONTH DOW DURATION\", e.g. \"0 2 * * sun#1 4h\" for the first Sunday of each month; may be repeated, replaces --reboot-days, --start-time and --end-time")
+	rootCmd.PersistentFlags().StringVar(&rebootWindowAnnotationPrefix, "reboot-window-annotation-prefix", "weave.works/kured-reboot-",
+		"node annotations with this prefix followed by days, start-time, end-time or time-zone override the reboot window of a node, defaulting to --reboot-days, --start-time, --end-time and --time-zone (set to \"\" to disable)")
+	rootCmd.PersistentFlags().DurationVar(&minWindowRemaining, "min-window-remaining", 0,
+		"only start draining a node if at least this much time remains before the reboot window closes (default: 0, disabled)")
+	rootCmd.PersistentFlags().BoolVar(&abortDrainAtWindowEnd, "abort-drain-at-window-end", false,
+		"abort a drain still running when the reboot window closes, uncordon the node and retry during the next window")
+	rootCmd.PersistentFlags().StringSliceVar(&blackoutDates, "blackout-dates", nil,
+		"never reboot on these dates or inclusive date ranges, given as YYYY-MM-DD or YYYY-MM-DD..YYYY-MM-DD, regardless of the reboot windows")
+	rootCmd.PersistentFlags().StringVar(&blackoutCalendar, "blackout-calendar", "",
+		"never reboot during the events of this iCalendar (.ics) file, regardless of the reboot windows")
+	rootCmd.PersistentFlags().IntVar(&maxRebootsPerHour, "max-reboots-per-hour", 0,
+		"maximum number of reboots started across the cluster in any hour (default: 0, unlimited)")
+	rootCmd.PersistentFlags().IntVar(&maxRebootsPerDay, "max-reboots-per-day", 0,
+		"maximum number of reboots started across the cluster in any 24 hours (default: 0, unlimited)")
+	rootCmd.PersistentFlags().IntVar(&maxConsecutiveFailures, "max-consecutive-failures", 0,
+		"stop all reboots after this many failed reboots in a row, until the circuit breaker is reset (default: 0, disabled)")
+	rootCmd.PersistentFlags().StringVar(&rebootHistoryAnnotation, "reboot-history-annotation", "weave.works/kured-reboot-history",
+		"annotation in which to keep the reboot history used by the rate limits and the circuit breaker")
+	rootCmd.PersistentFlags().BoolVar(&watchRebootPolicies, "watch-reboot-policies", false,
+		"apply the RebootPolicy custom resources matching each node in place of --config, and maintain their status")
+	rootCmd.PersistentFlags().BoolVar(&watchRebootRequests, "watch-reboot-requests", false,
+		"reboot nodes targeted by NodeRebootRequest custom resources, as if they required a reboot, and track progress in their status")
 
 	if err := rootCmd.Execute(); err != nil {
 		log.Fatal(err)
@@ -168,103 +346,125 @@ func rebootRequired() bool {
 	return false
 }
 
-func rebootBlocked(client *kubernetes.Clientset, nodeID string) bool {
-	if prometheusURL != "" {
-		alertNames, err := alerts.PrometheusActiveAlerts(prometheusURL, alertFilter)
-		if err != nil {
-			log.Warnf("Reboot blocked: prometheus query error: %v", err)
-			return true
-		}
-		count := len(alertNames)
-		if count > 10 {
-			alertNames = append(alertNames[:10], "...")
-		}
-		if count > 0 {
-			log.Warnf("Reboot blocked: %d active alerts: %v", count, alertNames)
-			return true
-		}
-	}
-
-	fieldSelector := fmt.Sprintf("spec.nodeName=%s", nodeID)
-	for _, labelSelector := range podSelectors {
-		podList, err := client.CoreV1().Pods("").List(context.TODO(), metav1.ListOptions{
-			LabelSelector: labelSelector,
-			FieldSelector: fieldSelector,
-			Limit:         10})
-		if err != nil {
-			log.Warnf("Reboot blocked: pod query error: %v", err)
-			return true
-		}
+// rebootReasons describes why the node needs to be rebooted.
+func rebootReasons() []string {
+	return []string{fmt.Sprintf("reboot sentinel %s present", rebootSentinel)}
+}
 
-		if len(podList.Items) > 0 {
-			podNames := make([]string, 0, len(podList.Items))
-			for _, pod := range podList.Items {
-				podNames = append(podNames, pod.Name)
-			}
-			if len(podList.Continue) > 0 {
-				podNames = append(podNames, "...")
+// rebootBlocked checks the blockers, returning which block the reboot.
+func rebootBlocked(ctx context.Context, registry *blockers.Registry, nodeID string) (bool, string) {
+	results := registry.Check(ctx)
+	var reasons []string
+	for _, result := range results {
+		if result.Blocked {
+			log.Warnf("Reboot blocked by %s: %s", result.Name, result.Reason)
+			reasons = append(reasons, fmt.Sprintf("blocked by %s: %s", result.Name, result.Reason))
+			rebootBlockedGauge.WithLabelValues(nodeID, result.Name).Set(1)
+		} else {
+			if result.Reason != "" {
+				log.Infof("Reboot not blocked by %s: %s", result.Name, result.Reason)
 			}
-			log.Warnf("Reboot blocked: matching pods: %v", podNames)
-			return true
+			rebootBlockedGauge.WithLabelValues(nodeID, result.Name).Set(0)
 		}
 	}
-
-	return false
+	return blockers.Blocked(results), strings.Join(reasons, "; ")
 }
 
-func holding(lock *daemonsetlock.DaemonSetLock, metadata interface{}) bool {
-	holding, err := lock.Test(metadata)
-	if err != nil {
-		log.Fatalf("Error testing lock: %v", err)
+func holding(ctx context.Context, lock *daemonsetlock.DaemonSetLock, metadata interface{}) (bool, error) {
+	var holding bool
+	if err := withRetries(ctx, "testing lock", func() (err error) {
+		holding, err = lock.Test(ctx, metadata)
+		return err
+	}); err != nil {
+		return false, fmt.Errorf("Error testing lock: %w", err)
 	}
 	if holding {
 		log.Infof("Holding lock")
 	}
-	return holding
+	return holding, nil
 }
 
-func acquire(lock *daemonsetlock.DaemonSetLock, metadata interface{}, TTL time.Duration) bool {
-	holding, holder, err := lock.Acquire(metadata, TTL)
-	switch {
-	case err != nil:
-		log.Fatalf("Error acquiring lock: %v", err)
-		return false
-	case !holding:
+// acquire attempts to acquire the lock. If this takes over the expired lock
+// of a node, its ID is returned, with the metadata it recorded in the lock.
+func acquire(ctx context.Context, lock *daemonsetlock.DaemonSetLock, metadata interface{}, TTL time.Duration) (bool, string, *nodeMeta, error) {
+	var holding bool
+	var holder, expired string
+	var expiredMeta *nodeMeta
+	if err := withRetries(ctx, "acquiring lock", func() (err error) {
+		expiredMeta = &nodeMeta{}
+		holding, holder, expired, err = lock.TakeOver(ctx, metadata, TTL, expiredMeta)
+		return err
+	}); err != nil {
+		return false, "", nil, fmt.Errorf("Error acquiring lock: %w", err)
+	}
+	if !holding {
 		log.Warnf("Lock already held: %v", holder)
-		return false
-	default:
-		log.Infof("Acquired reboot lock")
-		return true
+		return false, "", nil, nil
+	}
+	if expired != "" {
+		log.Infof("Acquired reboot lock, which expired while held by node %s", expired)
+		return true, expired, expiredMeta, nil
 	}
+	log.Infof("Acquired reboot lock")
+	return true, "", nil, nil
 }
 
-func release(lock *daemonsetlock.DaemonSetLock) {
+// release releases the lock. A lock lost meanwhile, e.g. as it expired and
+// another node took it over, is left alone.
+func release(ctx context.Context, lock *daemonsetlock.DaemonSetLock) error {
 	log.Infof("Releasing lock")
-	if err := lock.Release(); err != nil {
-		log.Fatalf("Error releasing lock: %v", err)
+	if err := withRetries(ctx, "releasing lock", func() error {
+		return lock.Release(ctx)
+	}); err != nil {
+		if errorclass.Classify(err) == errorclass.Stale {
+			log.Warnf("Lock lost before release: %v", err)
+			return nil
+		}
+		return fmt.Errorf("Error releasing lock: %w", err)
 	}
+	return nil
 }
 
-func drain(client *kubernetes.Clientset, node *v1.Node) {
+// windowEndHorizon limits how far ahead the end of a reboot window is looked
+// for; windows open for longer are treated as not closing at all.
+const windowEndHorizon = 7 * 24 * time.Hour
+
+// cleanupTimeout bounds the cleanup after abandoning a reboot on shutdown,
+// which must complete within terminationGracePeriodSeconds of the kured pod.
+const cleanupTimeout = 30 * time.Second
+
+// healthCheckInterval is how often the health of a node is checked after
+// its reboot.
+const healthCheckInterval = 10 * time.Second
+
+// errDrainDeadline is returned by drain when the deadline has passed.
+var errDrainDeadline = errors.New("Drain did not finish before the end of the reboot window")
+
+// drain cordons and drains the node. Unless deadline is zero, waiting for
+// pods to be evicted is abandoned once it has passed; it is abandoned as well
+// once ctx is done, returning the error of ctx.
+func drain(ctx context.Context, client *kubernetes.Clientset, node *v1.Node, deadline time.Time, options drainOptions, n notifiers) error {
 	nodename := node.GetName()
 
 	log.Infof("Draining node %s", nodename)
 
-	if slackHookURL != "" {
-		if err := slack.NotifyDrain(slackHookURL, slackUsername, slackChannel, messageTemplateDrain, nodename); err != nil {
+	if n.slackHookURL != "" {
+		if err := slack.NotifyDrain(n.slackHookURL, n.slackUsername, n.slackChannel, n.messageTemplateDrain, nodename); err != nil {
 			log.Warnf("Error notifying slack: %v", err)
 		}
 	}
 
-	if teamsHookURL != "" {
-		if err := teams.NotifyDrain(teamsHookURL, messageTemplateDrain, nodename); err != nil {
+	if n.teamsHookURL != "" {
+		if err := teams.NotifyDrain(n.teamsHookURL, n.messageTemplateDrain, nodename); err != nil {
 			log.Warnf("Error notifying teams: %v", err)
 		}
 	}
 
 	drainer := &kubectldrain.Helper{
+		Ctx:                 ctx,
 		Client:              client,
-		GracePeriodSeconds:  -1,
+		GracePeriodSeconds:  options.gracePeriodSeconds,
+		Timeout:             options.timeout,
 		Force:               true,
 		DeleteLocalData:     true,
 		IgnoreAllDaemonSets: true,
@@ -272,38 +472,98 @@ func drain(client *kubernetes.Clientset, node *v1.Node) {
 		Out:                 os.Stdout,
 	}
 	if err := kubectldrain.RunCordonOrUncordon(drainer, node, true); err != nil {
-		log.Fatalf("Error cordonning %s: %v", nodename, err)
+		return fmt.Errorf("Error cordonning %s: %w", nodename, err)
+	}
+
+	// setTimeout limits the next wait for evictions to the deadline
+	setTimeout := func() error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if deadline.IsZero() {
+			return nil
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return errDrainDeadline
+		}
+		if options.timeout == 0 || remaining < options.timeout {
+			drainer.Timeout = remaining
+		}
+		return nil
+	}
+
+	if len(options.tiers) == 0 {
+		if err := setTimeout(); err != nil {
+			return err
+		}
+		if err := kubectldrain.RunNodeDrain(drainer, nodename); err != nil {
+			return drainError(ctx, err, deadline)
+		}
+		return nil
+	}
+
+	// Same as kubectldrain.RunNodeDrain, but evicting one tier at a time
+	list, errs := drainer.GetPodsForDeletion(nodename)
+	if errs != nil {
+		return utilerrors.NewAggregate(errs)
+	}
+	if warnings := list.Warnings(); warnings != "" {
+		log.Warnf("Draining %s: %s", nodename, warnings)
+	}
+
+	for _, group := range options.classifier.Partition(list.Pods(), options.tiers) {
+		log.Infof("Evicting %d %s pods from node %s", len(group.Pods), group.Name, nodename)
+		if err := setTimeout(); err != nil {
+			return err
+		}
+		if err := drainer.DeleteOrEvictPods(group.Pods); err != nil {
+			return drainError(ctx, err, deadline)
+		}
 	}
+	return nil
+}
 
-	if err := kubectldrain.RunNodeDrain(drainer, nodename); err != nil {
-		log.Fatalf("Error draining %s: %v", nodename, err)
+// drainError returns errDrainDeadline in place of err if the drain failed
+// because the deadline passed, or the error of ctx if it is done.
+func drainError(ctx context.Context, err error, deadline time.Time) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		return errDrainDeadline
 	}
+	return err
 }
 
-func uncordon(client *kubernetes.Clientset, node *v1.Node) {
+func uncordon(ctx context.Context, client *kubernetes.Clientset, node *v1.Node) error {
 	nodename := node.GetName()
 	log.Infof("Uncordoning node %s", nodename)
 	drainer := &kubectldrain.Helper{
+		Ctx:    ctx,
 		Client: client,
 		ErrOut: os.Stderr,
 		Out:    os.Stdout,
 	}
-	if err := kubectldrain.RunCordonOrUncordon(drainer, node, false); err != nil {
-		log.Fatalf("Error uncordonning %s: %v", nodename, err)
+	if err := withRetries(ctx, "uncordonning node", func() error {
+		return kubectldrain.RunCordonOrUncordon(drainer, node, false)
+	}); err != nil {
+		return fmt.Errorf("Error uncordonning %s: %w", nodename, err)
 	}
+	return nil
 }
 
-func commandReboot(nodeID string) {
+func commandReboot(nodeID string, n notifiers) {
 	log.Infof("Commanding reboot for node: %s", nodeID)
 
-	if slackHookURL != "" {
-		if err := slack.NotifyReboot(slackHookURL, slackUsername, slackChannel, messageTemplateReboot, nodeID); err != nil {
+	if n.slackHookURL != "" {
+		if err := slack.NotifyReboot(n.slackHookURL, n.slackUsername, n.slackChannel, n.messageTemplateReboot, nodeID); err != nil {
 			log.Warnf("Error notifying slack: %v", err)
 		}
 	}
 
-	if teamsHookURL != "" {
-		if err := teams.NotifyReboot(teamsHookURL, messageTemplateReboot, nodeID); err != nil {
+	if n.teamsHookURL != "" {
+		if err := teams.NotifyReboot(n.teamsHookURL, n.messageTemplateReboot, nodeID); err != nil {
 			log.Warnf("Error notifying teams: %v", err)
 		}
 	}
@@ -315,6 +575,45 @@ func commandReboot(nodeID string) {
 	}
 }
 
+// silenceNodeAlerts creates Alertmanager silences for alerts about the node
+// and adds them to nodeMeta, so that they can be expired after the reboot.
+func silenceNodeAlerts(nodeMeta *nodeMeta, nodeID string) {
+	if silenceAlertmanagerURL == "" {
+		return
+	}
+
+	// Also match instance labels of the form host:port
+	value := regexp.QuoteMeta(nodeID) + "(:[0-9]+)?"
+	for _, label := range silenceLabels {
+		matcher, err := alerts.NewMatcher(label, alerts.MatchRegexp, value)
+		if err != nil {
+			log.Warnf("Error silencing alerts: %v", err)
+			continue
+		}
+
+		id, err := alerts.CreateSilence(silenceAlertmanagerURL, alerts.Matchers{matcher}, silenceDuration,
+			"kured", fmt.Sprintf("Rebooting node %s", nodeID))
+		if err != nil {
+			log.Warnf("Error silencing alerts matching %v: %v", matcher, err)
+			continue
+		}
+		log.Infof("Silenced alerts matching %v: %s", matcher, id)
+		nodeMeta.SilenceIDs = append(nodeMeta.SilenceIDs, id)
+	}
+}
+
+// expireNodeAlertSilences expires the silences created by silenceNodeAlerts.
+func expireNodeAlertSilences(nodeMeta *nodeMeta) {
+	for _, id := range nodeMeta.SilenceIDs {
+		if err := alerts.ExpireSilence(silenceAlertmanagerURL, id); err != nil {
+			log.Warnf("Error expiring silence %s: %v", id, err)
+			continue
+		}
+		log.Infof("Expired silence %s", id)
+	}
+	nodeMeta.SilenceIDs = nil
+}
+
 func maintainRebootRequiredMetric(nodeID string) {
 	for {
 		if sentinelExists() {
@@ -328,10 +627,21 @@ func maintainRebootRequiredMetric(nodeID string) {
 
 // nodeMeta is used to remember information across reboots
 type nodeMeta struct {
-	Unschedulable bool `json:"unschedulable"`
+	Unschedulable bool     `json:"unschedulable"`
+	SilenceIDs    []string `json:"silenceIDs,omitempty"`
+	// BootID is the boot ID of the node before the reboot
+	BootID string `json:"bootID,omitempty"`
+}
+
+// rebooted determines whether the node rebooted since nodeMeta was recorded.
+func (nm *nodeMeta) rebooted(node *v1.Node) bool {
+	return nm.BootID == "" || nm.BootID != node.Status.NodeInfo.BootID
 }
 
-func rebootAsRequired(nodeID string, window *timewindow.TimeWindow, TTL time.Duration) {
+// rebootAsRequired reboots the node whenever required, until ctx is done. A
+// drain in progress is then abandoned, uncordoning the node and releasing
+// the lock; once the reboot has been commanded, it is left to complete.
+func rebootAsRequired(ctx context.Context, nodeID string, cfg *config, policies *policyWatcher, reboots *requestWatcher, TTL time.Duration) {
 	config, err := rest.InClusterConfig()
 	if err != nil {
 		log.Fatal(err)
@@ -343,67 +653,539 @@ func rebootAsRequired(nodeID string, window *timewindow.TimeWindow, TTL time.Dur
 	}
 
 	lock := daemonsetlock.New(client, nodeID, dsNamespace, dsName, lockAnnotation)
+	history := newRebootHistory(client)
+	if history != nil {
+		go maintainRateLimitMetrics(ctx, history)
+	}
+
+	node, err := client.CoreV1().Nodes().Get(ctx, nodeID, metav1.GetOptions{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	rebooting := blockers.LockHolder(lock.Holder)
+	ns := &nodeSettings{client: client, nodeID: nodeID, rebooting: rebooting, cfg: cfg, policies: policies}
+	if err := ns.update(node); err != nil {
+		log.Fatal(err)
+	}
+
+	states, err := nodestate.New(client, node)
+	if err != nil {
+		log.Warnf("Ignoring recorded state of node %s: %v", nodeID, err)
+	}
 
 	nodeMeta := nodeMeta{}
-	if holding(lock, &nodeMeta) {
-		if !nodeMeta.Unschedulable {
-			node, err := client.CoreV1().Nodes().Get(context.TODO(), nodeID, metav1.GetOptions{})
-			if err != nil {
-				log.Fatal(err)
+
+	// rebootNode drains and reboots the node while holding the lock. It
+	// returns an error if the drain is abandoned at deadline, on shutdown or
+	// after it failed, and nil once ctx is done after commanding the reboot.
+	rebootNode := func(node *v1.Node, requests []*rebootrequest.NodeRebootRequest, deadline time.Time, drainNode bool) error {
+		track := func(phase rebootrequest.Phase, message string) {
+			if reboots != nil {
+				reboots.track(ctx, requests, node, phase, message)
+			}
+		}
+
+		if drainNode && !nodeMeta.Unschedulable {
+			track(rebootrequest.Draining, "")
+			enterState(ctx, states, nodestate.Draining, "")
+			if err := drain(ctx, client, node, deadline, ns.settings.drain, ns.settings.notifiers); err != nil {
+				if err != errDrainDeadline {
+					countError(err)
+				}
+				return err
+			}
+		}
+		if len(nodeMeta.SilenceIDs) == 0 {
+			silenceNodeAlerts(&nodeMeta, nodeID)
+			if len(nodeMeta.SilenceIDs) > 0 {
+				if err := lock.Update(ctx, nodeMeta); err != nil {
+					if countError(err) == errorclass.Stale {
+						// Rebooting without the lock could disrupt the node holding it now
+						return err
+					}
+					log.Warnf("Error recording silences in lock: %v", err)
+				}
+			}
+		}
+		logRequests(requests)
+		track(rebootrequest.Rebooting, "")
+		enterState(ctx, states, nodestate.Rebooting, "")
+		recordRebootStarted(ctx, history, nodeID)
+		commandReboot(nodeID, ns.settings.notifiers)
+		for {
+			log.Infof("Waiting for reboot")
+			select {
+			case <-ctx.Done():
+				// Most likely terminated by the reboot itself
+				return nil
+			case <-time.After(time.Minute):
+			}
+		}
+	}
+
+	// releaseLock releases the lock, forgetting what was recorded in it for
+	// this reboot, also if the lock was lost meanwhile.
+	releaseLock := func(ctx context.Context) error {
+		if err := release(ctx, lock); err != nil {
+			return err
+		}
+		nodeMeta.SilenceIDs = nil
+		nodeMeta.BootID = ""
+		return nil
+	}
+
+	// abandonReboot uncordons the node and releases the lock after its drain
+	// was abandoned. This must complete even if kured is shutting down, within
+	// the grace period of its pod.
+	abandonReboot := func(node *v1.Node, requests []*rebootrequest.NodeRebootRequest, err error) {
+		reason := drainAbandoned(ctx, err)
+		log.Warnf("Abandoning reboot of node %s: %s", nodeID, reason)
+		cleanupCtx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+		defer cancel()
+		if err := uncordon(cleanupCtx, client, node); err != nil {
+			giveUp(err)
+		}
+		if err := releaseLock(cleanupCtx); err != nil {
+			giveUp(err)
+		}
+		if reboots != nil {
+			if drainFailed(ctx, err) {
+				// The drain is retried for the reboot sentinel only
+				reboots.track(cleanupCtx, requests, node, rebootrequest.Failed, fmt.Sprintf("drain failed: %v", err))
+			} else {
+				reboots.track(cleanupCtx, requests, node, rebootrequest.Pending, reason)
+			}
+		}
+		enterState(cleanupCtx, states, nodestate.RebootRequired, reason)
+		if errorclass.Denied(err) {
+			// Retrying is pointless until the permissions of kured are fixed
+			log.Fatalf("Error draining %s: %v", nodeID, err)
+		}
+	}
+
+	// resume picks up where the previous kured pod left off: it resumes a
+	// drain or reboot interrupted by a restart, or completes the reboot once
+	// the node is back. It returns false if an error left this to be tried
+	// again at the next check, and true once ctx is done, which the caller
+	// checks.
+	var unhealthy error
+	verified := false
+	resume := func(node *v1.Node) bool {
+		held, err := holding(ctx, lock, &nodeMeta)
+		if err != nil {
+			giveUp(err)
+			return false
+		}
+		if state := states.State(); held && !verified && (state == nodestate.Draining || state == nodestate.Rebooting) && !nodeMeta.rebooted(node) {
+			// kured was restarted before the node went down
+			log.Infof("Resuming reboot of node %s from state %s", nodeID, state)
+			var requests []*rebootrequest.NodeRebootRequest
+			if reboots != nil {
+				requests = reboots.due(ctx, node)
+			}
+			if err := rebootNode(node, requests, time.Time{}, state == nodestate.Draining); err != nil {
+				abandonReboot(node, requests, err)
+			}
+			return true
+		} else if held {
+			// Back from a reboot, whichever state was recorded before
+			if !verified {
+				if err := states.Reset(ctx, nodestate.Verifying, ""); err != nil {
+					log.Warnf("Error recording state of node %s: %v", nodeID, err)
+				}
+				if !nodeMeta.Unschedulable {
+					if err := uncordon(ctx, client, node); err != nil {
+						giveUp(err)
+						return false
+					}
+				}
+				expireNodeAlertSilences(&nodeMeta)
+				// Keep holding the lock until the node is healthy
+				unhealthy = checkRebootHealth(ctx, client, nodeID, ns.registry, ns.settings)
+				if unhealthy == ctx.Err() {
+					// Left to the next kured pod, which will find the lock held
+					log.Infof("Shutting down before node %s is healthy, keeping the lock", nodeID)
+					return true
+				} else if unhealthy != nil {
+					enterState(ctx, states, nodestate.Failed, unhealthy.Error())
+				} else {
+					enterState(ctx, states, nodestate.Done, "")
+				}
+				// Without a reboot, e.g. after a failed drain, nothing completed
+				if nodeMeta.rebooted(node) {
+					recordRebootCompleted(ctx, client, nodeID)
+					recordRebootOutcome(ctx, history, nodeID, unhealthy, ns.settings.notifiers)
+				}
+				verified = true
+			}
+			if policies != nil {
+				// Last status update as the lock holder, with the reboot done
+				reportRebootRequired(ctx, client, node, rebootRequired())
+				updatePolicyStatuses(ctx, client, policies, func(node *v1.Node) bool {
+					return false
+				})
+			}
+			if err := releaseLock(ctx); err != nil {
+				giveUp(err)
+				return false
+			}
+		} else if state == nodestate.Draining || state == nodestate.Rebooting || state == nodestate.Verifying {
+			// The lock expired or was released meanwhile
+			if err := states.Reset(ctx, nodestate.Failed, "reboot abandoned, lock no longer held"); err != nil {
+				log.Warnf("Error recording state of node %s: %v", nodeID, err)
 			}
-			uncordon(client, node)
 		}
-		release(lock)
+		if reboots != nil {
+			reboots.complete(ctx, node, unhealthy)
+		}
+		return true
 	}
 
-	preferNoScheduleTaint := taints.New(client, nodeID, preferNoScheduleTaintName, v1.TaintEffectPreferNoSchedule)
+	resumed := resume(node)
+	if ctx.Err() != nil {
+		return
+	}
+
+	var preferNoScheduleTaint *taints.Taint
+	if err := withRetries(ctx, "reading taints", func() (err error) {
+		preferNoScheduleTaint, err = taints.New(ctx, client, nodeID, preferNoScheduleTaintName, v1.TaintEffectPreferNoSchedule)
+		return err
+	}); err != nil {
+		log.Fatalf("Error reading taints of node %s: %v", nodeID, err)
+	}
+	// setTaint adds or removes the taint, leaving it to the next period to
+	// try again if this fails.
+	setTaint := func(enable bool) {
+		set, what := preferNoScheduleTaint.Disable, "removing taint"
+		if enable {
+			set, what = preferNoScheduleTaint.Enable, "adding taint"
+		}
+		if err := withRetries(ctx, what, func() error {
+			return set(ctx)
+		}); err != nil {
+			giveUp(fmt.Errorf("Error %s: %w", what, err))
+		}
+	}
 
 	// Remove taint immediately during startup to quickly allow scheduling again.
 	if !rebootRequired() {
-		preferNoScheduleTaint.Disable()
+		setTaint(false)
 	}
 
 	source := rand.NewSource(time.Now().UnixNano())
 	tick := delaytick.New(source, period)
-	for range tick {
+	var window timewindow.Window = ns.settings.schedule
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+		}
+
+		node, err := client.CoreV1().Nodes().Get(ctx, nodeID, metav1.GetOptions{})
+		if err != nil {
+			countError(err)
+			giveUp(fmt.Errorf("Failed to get node %s: %w", nodeID, err))
+			continue
+		}
+		if !resumed {
+			resumed = resume(node)
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+
+		var requests []*rebootrequest.NodeRebootRequest
+		if reboots != nil {
+			requests = reboots.due(ctx, node)
+		}
+		track := func(phase rebootrequest.Phase, message string) {
+			if reboots != nil {
+				reboots.track(ctx, requests, node, phase, message)
+			}
+		}
+
+		required := rebootRequired() || len(requests) > 0
+		reportRebootRequired(ctx, client, node, required)
+		if !required {
+			enterState(ctx, states, nodestate.Idle, "")
+		} else if state := states.State(); state != nodestate.RebootRequired && state != nodestate.WaitingForLock {
+			enterState(ctx, states, nodestate.RebootRequired, "")
+		}
+		if err := ns.update(node); err != nil {
+			log.Warnf("Ignoring invalid settings: %v", err)
+		}
+		schedule := ns.settings.schedule
+		nodeSchedule, err := nodeRebootSchedule(node, schedule)
+		if err != nil {
+			log.Warnf("Ignoring invalid reboot window annotations on node %s: %v", nodeID, err)
+			nodeSchedule = schedule
+		}
+		if nodeSchedule.String() != window.String() {
+			log.Infof("Reboot on: %v", nodeSchedule)
+		}
+		window = nodeSchedule
+		currentWindow.Store(window)
+
 		if !window.Contains(time.Now()) {
 			// Remove taint outside the reboot time window to allow for normal operation.
-			preferNoScheduleTaint.Disable()
+			setTaint(false)
+			track(rebootrequest.Pending, "outside of reboot window")
+			if required {
+				enterState(ctx, states, nodestate.RebootRequired, "outside of reboot window")
+			}
 			continue
 		}
 
-		if !rebootRequired() {
-			preferNoScheduleTaint.Disable()
+		if !required {
+			setTaint(false)
 			continue
 		}
 
-		if rebootBlocked(client, nodeID) {
+		windowEnd, windowCloses := timewindow.End(window, time.Now(), windowEndHorizon)
+		if windowCloses && time.Until(windowEnd) < minWindowRemaining {
+			log.Infof("Reboot window closes at %v, less than %v from now, deferring reboot", windowEnd, minWindowRemaining)
+			track(rebootrequest.Pending, "too close to the end of the reboot window")
+			enterState(ctx, states, nodestate.RebootRequired, "too close to the end of the reboot window")
 			continue
 		}
 
-		node, err := client.CoreV1().Nodes().Get(context.TODO(), nodeID, metav1.GetOptions{})
-		if err != nil {
-			log.Fatal(err)
+		if blocked, reason := rebootBlocked(ctx, ns.registry, nodeID); blocked {
+			track(rebootrequest.Blocked, reason)
+			enterState(ctx, states, nodestate.RebootRequired, reason)
+			continue
 		}
+
 		nodeMeta.Unschedulable = node.Spec.Unschedulable
+		nodeMeta.BootID = node.Status.NodeInfo.BootID
 
-		if !acquire(lock, &nodeMeta, TTL) {
+		acquired, expired, expiredMeta, err := acquire(ctx, lock, &nodeMeta, TTL)
+		if err != nil {
+			giveUp(err)
+			continue
+		}
+		if expired != "" {
+			if expiredNode, err := client.CoreV1().Nodes().Get(ctx, expired, metav1.GetOptions{}); err != nil {
+				log.Warnf("Error checking node %s, whose lock expired: %v", expired, err)
+			} else {
+				recordStaleReboot(ctx, history, expiredNode, expiredMeta, "its lock expired", ns.settings.notifiers)
+			}
+		}
+		if !acquired {
 			// Prefer to not schedule pods onto this node to avoid draing the same pod multiple times.
-			preferNoScheduleTaint.Enable()
+			setTaint(true)
+			track(rebootrequest.Pending, "waiting for the lock")
+			enterState(ctx, states, nodestate.WaitingForLock, "")
 			continue
 		}
+		if policies != nil {
+			// Only the lock holder writes statuses, rather than every kured pod
+			updatePolicyStatuses(ctx, client, policies, func(node *v1.Node) bool {
+				return node.Name == nodeID
+			})
+		}
 
-		if !nodeMeta.Unschedulable {
-			drain(client, node)
+		var deadline time.Time
+		if abortDrainAtWindowEnd && windowCloses {
+			deadline = windowEnd
 		}
-		commandReboot(nodeID)
-		for {
-			log.Infof("Waiting for reboot")
-			time.Sleep(time.Minute)
+		if err := rebootNode(node, requests, deadline, true); err != nil {
+			abandonReboot(node, requests, err)
+		}
+		if ctx.Err() != nil {
+			return
 		}
 	}
 }
 
+// drainAbandoned describes why a drain was abandoned with err.
+func drainAbandoned(ctx context.Context, err error) string {
+	switch {
+	case err == errDrainDeadline:
+		return "drain aborted at the end of the reboot window"
+	case ctx.Err() != nil:
+		return "drain interrupted by shutdown"
+	case errorclass.Classify(err) == errorclass.Stale:
+		return fmt.Sprintf("lock lost: %v", err)
+	default:
+		return fmt.Sprintf("drain failed, retrying later: %v", err)
+	}
+}
+
+// drainFailed determines whether a drain was abandoned with err because it
+// failed, rather than being interrupted.
+func drainFailed(ctx context.Context, err error) bool {
+	return err != errDrainDeadline && ctx.Err() == nil && errorclass.Classify(err) != errorclass.Stale
+}
+
+// enterState records the transition of the node into state, logging
+// failures, which must not hold up the reboot.
+func enterState(ctx context.Context, states *nodestate.Machine, state nodestate.State, message string) {
+	if err := states.Transition(ctx, state, message); err != nil {
+		log.Warnf("Error recording node state: %v", err)
+	}
+}
+
+// checkRebootHealth waits for a node which rebooted in a rollout to become
+// ready and pass the health checks. If it does not in time, the node is
+// marked as failed, which halts the rollout.
+func checkRebootHealth(ctx context.Context, client kubernetes.Interface, nodeID string, registry *blockers.Registry, st *settings) error {
+	if st.rollout == nil {
+		return nil
+	}
+
+	deadline := time.Now().Add(st.health.timeout)
+	for {
+		reason := nodeUnhealthy(ctx, client, nodeID, registry, st.health.checks)
+		if reason == "" {
+			log.Infof("Node %s is healthy after its reboot", nodeID)
+			return nil
+		}
+		if time.Now().After(deadline) {
+			recordRebootFailed(ctx, client, nodeID, reason, st.notifiers)
+			return errors.New(reason)
+		}
+		log.Infof("Waiting for node %s to become healthy: %s", nodeID, reason)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(healthCheckInterval):
+		}
+	}
+}
+
+// nodeUnhealthy returns why the node is unhealthy, or "" if it is healthy.
+func nodeUnhealthy(ctx context.Context, client kubernetes.Interface, nodeID string, registry *blockers.Registry, checks []string) string {
+	node, err := client.CoreV1().Nodes().Get(ctx, nodeID, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Sprintf("node query error: %v", err)
+	}
+	ready := false
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == v1.NodeReady {
+			ready = condition.Status == v1.ConditionTrue
+		}
+	}
+	if !ready {
+		return "node not ready"
+	}
+
+	var reasons []string
+	for _, result := range registry.CheckNamed(ctx, checks...) {
+		if result.Blocked {
+			reasons = append(reasons, fmt.Sprintf("%s: %s", result.Name, result.Reason))
+		}
+	}
+	return strings.Join(reasons, "; ")
+}
+
+// recordRebootFailed marks the node as failed after its reboot, halting the
+// rollout, and notifies about it.
+func recordRebootFailed(ctx context.Context, client kubernetes.Interface, nodeID, reason string, n notifiers) {
+	log.Errorf("Node %s is unhealthy after its reboot, halting rollout: %s", nodeID, reason)
+	if err := controller.PatchNodeAnnotations(ctx, client, nodeID, map[string]*string{controller.RebootFailedAnnotation: &reason}); err != nil {
+		log.Warnf("Error recording reboot failure: %v", err)
+	}
+
+	if n.slackHookURL != "" {
+		if err := slack.NotifyRolloutHalted(n.slackHookURL, n.slackUsername, n.slackChannel, n.messageTemplateRolloutHalted, nodeID, reason); err != nil {
+			log.Warnf("Error notifying slack: %v", err)
+		}
+	}
+
+	if n.teamsHookURL != "" {
+		if err := teams.NotifyRolloutHalted(n.teamsHookURL, n.messageTemplateRolloutHalted, nodeID, reason); err != nil {
+			log.Warnf("Error notifying teams: %v", err)
+		}
+	}
+}
+
+// reportRebootRequired records in the node annotations whether it requires a
+// reboot, for the kured controller and the RebootPolicy statuses.
+func reportRebootRequired(ctx context.Context, client kubernetes.Interface, node *v1.Node, required bool) {
+	if _, reported := node.Annotations[controller.RebootRequiredAnnotation]; required == reported {
+		return
+	}
+	var value *string
+	if required {
+		now := time.Now().UTC().Format(time.RFC3339)
+		value = &now
+	}
+	if err := controller.PatchNodeAnnotations(ctx, client, node.Name, map[string]*string{controller.RebootRequiredAnnotation: value}); err != nil {
+		log.Warnf("Error reporting reboot state: %v", err)
+	}
+}
+
+// recordRebootCompleted records the time the reboot of the node completed.
+func recordRebootCompleted(ctx context.Context, client kubernetes.Interface, nodeID string) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	if err := controller.PatchNodeAnnotations(ctx, client, nodeID, map[string]*string{controller.LastRebootAnnotation: &now}); err != nil {
+		log.Warnf("Error recording reboot completion: %v", err)
+	}
+	log.Infof("Reboot of node %s completed", nodeID)
+}
+
+// watchPolicies starts watching RebootPolicies.
+func watchPolicies() *policyWatcher {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	policies, err := newPolicyWatcher(client, make(chan struct{}))
+	if err != nil {
+		log.Fatal(err)
+	}
+	return policies
+}
+
+// watchRequests starts watching NodeRebootRequests.
+func watchRequests() *requestWatcher {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	nodes, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	requests, err := newRequestWatcher(client, nodes, make(chan struct{}))
+	if err != nil {
+		log.Fatal(err)
+	}
+	return requests
+}
+
+// logRequests logs why the node is rebooted on request.
+func logRequests(requests []*rebootrequest.NodeRebootRequest) {
+	for _, request := range requests {
+		if request.Spec.Reason != "" {
+			log.Infof("Reboot requested by NodeRebootRequest %s: %s", request.Name, request.Spec.Reason)
+		} else {
+			log.Infof("Reboot requested by NodeRebootRequest %s", request.Name)
+		}
+	}
+}
+
+// updatePolicyStatuses writes the status of every RebootPolicy from the
+// current state of the nodes.
+func updatePolicyStatuses(ctx context.Context, client kubernetes.Interface, policies *policyWatcher, inProgress func(node *v1.Node) bool) {
+	nodeList, err := client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		log.Warnf("Error listing nodes: %v", err)
+		return
+	}
+	policies.updatePolicyStatuses(ctx, nodeList.Items, inProgress)
+}
+
 func root(cmd *cobra.Command, args []string) {
 	log.Infof("Kubernetes Reboot Daemon: %s", version)
 
@@ -412,9 +1194,14 @@ func root(cmd *cobra.Command, args []string) {
 		log.Fatal("KURED_NODE_ID environment variable required")
 	}
 
-	window, err := timewindow.New(rebootDays, rebootStart, rebootEnd, timezone)
+	cfg, err := loadConfig(configFile)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	defaults, err := newSettings(cfg, "configuration")
 	if err != nil {
-		log.Fatalf("Failed to build time window: %v", err)
+		log.Fatalf("Invalid configuration: %v", err)
 	}
 
 	log.Infof("Node ID: %s", nodeID)
@@ -427,11 +1214,57 @@ func root(cmd *cobra.Command, args []string) {
 	log.Infof("PreferNoSchedule taint: %s", preferNoScheduleTaintName)
 	log.Infof("Reboot Sentinel: %s every %v", rebootSentinel, period)
 	log.Infof("Blocking Pod Selectors: %v", podSelectors)
-	log.Infof("Reboot on: %v", window)
+	if len(defaults.drain.tiers) > 0 {
+		log.Infof("Drain order: %v", defaults.drain.tiers)
+	}
+	log.Infof("Reboot on: %v", defaults.schedule)
+	for _, blackout := range defaults.blackouts {
+		log.Infof("Blackout: %v", blackout)
+	}
+
+	var policies *policyWatcher
+	if watchRebootPolicies {
+		log.Infof("Watching RebootPolicies")
+		policies = watchPolicies()
+	}
+	var reboots *requestWatcher
+	if watchRebootRequests {
+		log.Infof("Watching NodeRebootRequests")
+		reboots = watchRequests()
+	}
+
+	if rateLimited() {
+		log.Infof("Reboot limits: %d per hour, %d per day, %d consecutive failures", maxRebootsPerHour, maxRebootsPerDay, maxConsecutiveFailures)
+	}
 
-	go rebootAsRequired(nodeID, window, lockTTL)
 	go maintainRebootRequiredMetric(nodeID)
+	go func() {
+		http.Handle("/metrics", promhttp.Handler())
+		log.Fatal(http.ListenAndServe(":8080", nil))
+	}()
+
+	ctx := shutdownContext()
+	if agent {
+		log.Infof("Agent mode, reboots are approved by the kured controller")
+		agentRebootAsRequired(ctx, nodeID, cfg, policies, reboots)
+	} else {
+		currentWindow.Store(timewindow.Window(defaults.schedule))
+		go maintainNextWindowMetric(nodeID)
+		rebootAsRequired(ctx, nodeID, cfg, policies, reboots, lockTTL)
+	}
+	log.Infof("Shut down")
+}
 
-	http.Handle("/metrics", promhttp.Handler())
-	log.Fatal(http.ListenAndServe(":8080", nil))
+// shutdownContext returns a context which is cancelled once kured is asked
+// to terminate.
+func shutdownContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-signals
+		log.Infof("Received %v, shutting down", sig)
+		cancel()
+	}()
+	return ctx
 }
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/weaveworks/kured/pkg/controller"
+)
+
+// rebootPolicyResource identifies the RebootPolicy custom resource.
+var rebootPolicyResource = schema.GroupVersionResource{Group: "kured.weave.works", Version: "v1alpha1", Resource: "rebootpolicies"}
+
+// rebootPolicy configures kured for the nodes matching its node selector.
+type rebootPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   rebootPolicySpec   `json:"spec,omitempty"`
+	Status rebootPolicyStatus `json:"status,omitempty"`
+}
+
+// rebootPolicySpec takes the place of the configuration file for the nodes
+// matching NodeSelector.
+type rebootPolicySpec struct {
+	// NodeSelector selects the nodes the policy applies to; all nodes if nil.
+	NodeSelector *metav1.LabelSelector `json:"nodeSelector,omitempty"`
+
+	config `json:",inline"`
+}
+
+// rebootPolicyStatus summarizes the nodes a policy applies to.
+type rebootPolicyStatus struct {
+	NodesPending    []string    `json:"nodesPending,omitempty"`
+	NodesInProgress []string    `json:"nodesInProgress,omitempty"`
+	LastReboot      *lastReboot `json:"lastReboot,omitempty"`
+}
+
+// lastReboot records the most recently completed reboot.
+type lastReboot struct {
+	Node string      `json:"node"`
+	Time metav1.Time `json:"time"`
+}
+
+// policyWatcher keeps track of the RebootPolicies in the cluster.
+type policyWatcher struct {
+	client   dynamic.Interface
+	informer cache.SharedIndexInformer
+}
+
+// newPolicyWatcher starts watching RebootPolicies, until stop is closed.
+func newPolicyWatcher(client dynamic.Interface, stop <-chan struct{}) (*policyWatcher, error) {
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(client, 10*time.Minute)
+	informer := factory.ForResource(rebootPolicyResource).Informer()
+	factory.Start(stop)
+	if !cache.WaitForCacheSync(stop, informer.HasSynced) {
+		return nil, fmt.Errorf("Failed to list RebootPolicies")
+	}
+	return &policyWatcher{client: client, informer: informer}, nil
+}
+
+// policies returns all RebootPolicies, ordered by name.
+func (pw *policyWatcher) policies() []*rebootPolicy {
+	var policies []*rebootPolicy
+	for _, obj := range pw.informer.GetStore().List() {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		policy, err := policyFromUnstructured(u)
+		if err != nil {
+			log.Warnf("Ignoring invalid RebootPolicy %s: %v", u.GetName(), err)
+			continue
+		}
+		policies = append(policies, policy)
+	}
+	sort.Slice(policies, func(i, j int) bool {
+		return policies[i].Name < policies[j].Name
+	})
+	return policies
+}
+
+// match returns the RebootPolicy applying to the node, which is the first by
+// name whose node selector matches, or nil if none does.
+func (pw *policyWatcher) match(node *v1.Node) *rebootPolicy {
+	return matchPolicy(pw.policies(), node)
+}
+
+// matchPolicy returns the first of policies whose node selector matches the
+// node, or nil if none does.
+func matchPolicy(policies []*rebootPolicy, node *v1.Node) *rebootPolicy {
+	for _, policy := range policies {
+		if policy.Spec.NodeSelector == nil {
+			return policy
+		}
+		selector, err := metav1.LabelSelectorAsSelector(policy.Spec.NodeSelector)
+		if err != nil {
+			log.Warnf("Ignoring RebootPolicy %s with invalid node selector: %v", policy.Name, err)
+			continue
+		}
+		if selector.Matches(labels.Set(node.Labels)) {
+			return policy
+		}
+	}
+	return nil
+}
+
+// policyFromUnstructured converts a RebootPolicy retrieved via the dynamic
+// client.
+func policyFromUnstructured(u *unstructured.Unstructured) (*rebootPolicy, error) {
+	data, err := u.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	policy := &rebootPolicy{}
+	if err := json.Unmarshal(data, policy); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// policySettings returns the settings from the policy, or from the
+// configuration file if policy is nil.
+func policySettings(policy *rebootPolicy, cfg *config) (*settings, *config, error) {
+	if policy == nil {
+		st, err := newSettings(cfg, "configuration")
+		return st, cfg, err
+	}
+	st, err := newSettings(&policy.Spec.config, fmt.Sprintf("RebootPolicy %s", policy.Name))
+	if err != nil {
+		return nil, nil, fmt.Errorf("RebootPolicy %s: %v", policy.Name, err)
+	}
+	return st, &policy.Spec.config, nil
+}
+
+// updatePolicyStatuses writes the status of every RebootPolicy, based on the
+// nodes it applies to. inProgress determines whether a node is rebooting.
+func (pw *policyWatcher) updatePolicyStatuses(ctx context.Context, nodes []v1.Node, inProgress func(node *v1.Node) bool) {
+	policies := pw.policies()
+	statuses := make(map[string]*rebootPolicyStatus, len(policies))
+	for _, policy := range policies {
+		statuses[policy.Name] = &rebootPolicyStatus{}
+	}
+
+	for i := range nodes {
+		node := &nodes[i]
+		policy := matchPolicy(policies, node)
+		if policy == nil {
+			continue
+		}
+		status := statuses[policy.Name]
+
+		if _, required := node.Annotations[controller.RebootRequiredAnnotation]; required {
+			if inProgress(node) {
+				status.NodesInProgress = append(status.NodesInProgress, node.Name)
+			} else {
+				status.NodesPending = append(status.NodesPending, node.Name)
+			}
+		}
+
+		if value, ok := node.Annotations[controller.LastRebootAnnotation]; ok {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				log.Warnf("Invalid %s annotation on node %s: %v", controller.LastRebootAnnotation, node.Name, err)
+				continue
+			}
+			if status.LastReboot == nil || t.After(status.LastReboot.Time.Time) {
+				status.LastReboot = &lastReboot{Node: node.Name, Time: metav1.NewTime(t)}
+			}
+		}
+	}
+
+	for _, policy := range policies {
+		if err := pw.updatePolicyStatus(ctx, policy, statuses[policy.Name]); err != nil {
+			log.Warnf("Error updating status of RebootPolicy %s: %v", policy.Name, err)
+		}
+	}
+}
+
+// updatePolicyStatus writes the status of a policy, if it changed. On
+// conflicts, the policy is read again, as the informer may lag behind.
+func (pw *policyWatcher) updatePolicyStatus(ctx context.Context, policy *rebootPolicy, status *rebootPolicyStatus) error {
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	value := map[string]interface{}{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	first := true
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var u *unstructured.Unstructured
+		if first {
+			first = false
+			obj, exists, err := pw.informer.GetStore().GetByKey(policy.Name)
+			if err != nil || !exists {
+				return err
+			}
+			u = obj.(*unstructured.Unstructured).DeepCopy()
+		} else {
+			if u, err = pw.client.Resource(rebootPolicyResource).Get(ctx, policy.Name, metav1.GetOptions{}); err != nil {
+				return err
+			}
+		}
+
+		current, err := policyFromUnstructured(u)
+		if err != nil {
+			return err
+		}
+		currentData, err := json.Marshal(current.Status)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(data, currentData) {
+			return nil
+		}
+		u.Object["status"] = value
+		_, err = pw.client.Resource(rebootPolicyResource).UpdateStatus(ctx, u, metav1.UpdateOptions{})
+		return err
+	})
+}
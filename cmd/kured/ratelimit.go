@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/weaveworks/kured/pkg/notifications/slack"
+	"github.com/weaveworks/kured/pkg/notifications/teams"
+	"github.com/weaveworks/kured/pkg/ratelimit"
+)
+
+// rebootLimits returns the cluster-wide limits given via command line flags.
+func rebootLimits() ratelimit.Limits {
+	return ratelimit.Limits{
+		PerHour:                maxRebootsPerHour,
+		PerDay:                 maxRebootsPerDay,
+		MaxConsecutiveFailures: maxConsecutiveFailures,
+	}
+}
+
+// rateLimited determines whether any cluster-wide limit is configured.
+func rateLimited() bool {
+	return rebootLimits() != ratelimit.Limits{}
+}
+
+// newRebootHistory returns the store of the cluster-wide reboot history, or
+// nil if no limit is configured and the history is not needed.
+func newRebootHistory(client kubernetes.Interface) *ratelimit.Store {
+	if !rateLimited() {
+		return nil
+	}
+	return ratelimit.NewStore(client, dsNamespace, dsName, rebootHistoryAnnotation)
+}
+
+// recordRebootStarted adds the reboot of the node to the history.
+func recordRebootStarted(ctx context.Context, history *ratelimit.Store, nodeID string) {
+	if history == nil {
+		return
+	}
+	if err := history.Update(ctx, func(h *ratelimit.History) error {
+		h.RecordReboot(nodeID, time.Now())
+		return nil
+	}); err != nil {
+		log.Warnf("Error recording reboot in history: %v", err)
+	}
+}
+
+// recordRebootOutcome records in the history whether the reboot of the node
+// failed, tripping the circuit breaker after too many failures in a row.
+func recordRebootOutcome(ctx context.Context, history *ratelimit.Store, nodeID string, failure error, n notifiers) {
+	if history == nil {
+		return
+	}
+	var trip *ratelimit.Trip
+	if err := history.Update(ctx, func(h *ratelimit.History) error {
+		if h.RecordOutcome(nodeID, failure != nil, time.Now(), rebootLimits()) {
+			trip = h.Tripped
+		}
+		return nil
+	}); err != nil {
+		log.Warnf("Error recording reboot outcome in history: %v", err)
+		return
+	}
+	if trip == nil {
+		return
+	}
+
+	log.Errorf("Circuit breaker tripped, stopping all reboots: %s", trip.Reason)
+	if n.slackHookURL != "" {
+		if err := slack.NotifyCircuitBreaker(n.slackHookURL, n.slackUsername, n.slackChannel, messageTemplateCircuitBreaker, trip.Reason); err != nil {
+			log.Warnf("Error notifying slack: %v", err)
+		}
+	}
+	if n.teamsHookURL != "" {
+		if err := teams.NotifyCircuitBreaker(n.teamsHookURL, messageTemplateCircuitBreaker, trip.Reason); err != nil {
+			log.Warnf("Error notifying teams: %v", err)
+		}
+	}
+}
+
+// recordStaleReboot records in the history that the reboot of the node
+// failed if it went stale, as described by how, without the node rebooting
+// since meta was recorded: such a reboot never returns to record its outcome.
+func recordStaleReboot(ctx context.Context, history *ratelimit.Store, node *v1.Node, meta *nodeMeta, how string, n notifiers) {
+	if meta.rebooted(node) {
+		return
+	}
+	failure := fmt.Errorf("Reboot of node %s went stale without the node rebooting: %s", node.Name, how)
+	log.Warn(failure)
+	recordRebootOutcome(ctx, history, node.Name, failure, n)
+}
+
+// maintainRateLimitMetrics publishes the state of the reboot history until
+// ctx is done.
+func maintainRateLimitMetrics(ctx context.Context, history *ratelimit.Store) {
+	for {
+		if h, err := history.Load(ctx); err != nil {
+			log.Warnf("Error loading reboot history: %v", err)
+		} else {
+			now := time.Now()
+			recentRebootsGauge.WithLabelValues("hour").Set(float64(h.Count(now.Add(-time.Hour))))
+			recentRebootsGauge.WithLabelValues("day").Set(float64(h.Count(now.Add(-24 * time.Hour))))
+			if h.Tripped != nil {
+				circuitBreakerGauge.Set(1)
+			} else {
+				circuitBreakerGauge.Set(0)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Minute):
+		}
+	}
+}
+
+// newResetCircuitBreakerCommand creates the reset-circuit-breaker
+// subcommand, which lets reboots resume after the circuit breaker tripped.
+func newResetCircuitBreakerCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "reset-circuit-breaker",
+		Short: "Resume reboots after the circuit breaker tripped",
+		Long: "Reset the circuit breaker stopping all reboots after too many failed reboots in a row. " +
+			"Runs in the cluster, e.g. via kubectl exec into a kured pod.",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			config, err := rest.InClusterConfig()
+			if err != nil {
+				log.Fatal(err)
+			}
+			client, err := kubernetes.NewForConfig(config)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			history := ratelimit.NewStore(client, dsNamespace, dsName, rebootHistoryAnnotation)
+			var tripped *ratelimit.Trip
+			if err := history.Update(cmd.Context(), func(h *ratelimit.History) error {
+				tripped = h.Tripped
+				h.Reset()
+				return nil
+			}); err != nil {
+				log.Fatalf("Error resetting circuit breaker: %v", err)
+			}
+			if tripped != nil {
+				fmt.Fprintf(cmd.OutOrStdout(), "Reset circuit breaker tripped at %v: %s\n", tripped.Time, tripped.Reason)
+			} else {
+				fmt.Fprintln(cmd.OutOrStdout(), "Circuit breaker was not tripped")
+			}
+		},
+	}
+}
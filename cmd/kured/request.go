@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/weaveworks/kured/pkg/rebootrequest"
+)
+
+// requestWatcher keeps track of the NodeRebootRequests in the cluster, and
+// records the progress of the reboots they request in their status.
+type requestWatcher struct {
+	client   dynamic.Interface
+	nodes    kubernetes.Interface
+	informer cache.SharedIndexInformer
+	// finished are the requests whose reboot of this node ended, which the
+	// informer may not have caught up with yet.
+	finished map[types.UID]bool
+}
+
+// newRequestWatcher starts watching NodeRebootRequests, until stop is
+// closed.
+func newRequestWatcher(client dynamic.Interface, nodes kubernetes.Interface, stop <-chan struct{}) (*requestWatcher, error) {
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(client, 10*time.Minute)
+	informer := factory.ForResource(rebootrequest.Resource).Informer()
+	factory.Start(stop)
+	if !cache.WaitForCacheSync(stop, informer.HasSynced) {
+		return nil, fmt.Errorf("Failed to list NodeRebootRequests")
+	}
+	return &requestWatcher{client: client, nodes: nodes, informer: informer, finished: map[types.UID]bool{}}, nil
+}
+
+// requests returns the requests targeting the node whose reboot of it has
+// not ended, ordered by name. Requests which have ended overall are left
+// alone, and the targets of new requests using a selector are recorded.
+func (rw *requestWatcher) requests(ctx context.Context, node *v1.Node) []*rebootrequest.NodeRebootRequest {
+	var requests []*rebootrequest.NodeRebootRequest
+	for _, obj := range rw.informer.GetStore().List() {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		request, err := requestFromUnstructured(u)
+		if err != nil {
+			log.Warnf("Ignoring invalid NodeRebootRequest %s: %v", u.GetName(), err)
+			continue
+		}
+		if request.Status.Phase.Final() {
+			continue
+		}
+		if request.NeedsTargets() {
+			if request, err = rw.recordTargets(ctx, request.Name); err != nil {
+				log.Warnf("Ignoring NodeRebootRequest %s: %v", u.GetName(), err)
+				continue
+			}
+		}
+		targets, err := request.Targets(node)
+		if err != nil {
+			log.Warnf("Ignoring NodeRebootRequest %s: %v", request.Name, err)
+			continue
+		}
+		if !targets || rw.finished[request.UID] || request.Status.Nodes[node.Name].Phase.Final() {
+			continue
+		}
+		requests = append(requests, request)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].Name < requests[j].Name
+	})
+	return requests
+}
+
+// due returns the requests for which the node is to be rebooted now. The
+// others are recorded as pending until their time has come.
+func (rw *requestWatcher) due(ctx context.Context, node *v1.Node) []*rebootrequest.NodeRebootRequest {
+	var due []*rebootrequest.NodeRebootRequest
+	now := time.Now()
+	for _, request := range rw.requests(ctx, node) {
+		if request.Due(now) {
+			due = append(due, request)
+			continue
+		}
+		rw.track(ctx, []*rebootrequest.NodeRebootRequest{request}, node, rebootrequest.Pending,
+			fmt.Sprintf("not before %v", request.Spec.NotBefore.Time))
+	}
+	return due
+}
+
+// complete records the reboots of the node commanded before it booted as
+// completed, or as failed if the node is unhealthy after its reboot.
+func (rw *requestWatcher) complete(ctx context.Context, node *v1.Node, unhealthy error) {
+	for _, request := range rw.requests(ctx, node) {
+		status := request.Status.Nodes[node.Name]
+		if status.Phase != rebootrequest.Rebooting || status.BootID == node.Status.NodeInfo.BootID {
+			continue
+		}
+		if unhealthy != nil {
+			rw.track(ctx, []*rebootrequest.NodeRebootRequest{request}, node, rebootrequest.Failed, fmt.Sprintf("unhealthy after reboot: %v", unhealthy))
+		} else {
+			rw.track(ctx, []*rebootrequest.NodeRebootRequest{request}, node, rebootrequest.Completed, "")
+		}
+	}
+}
+
+// track records the phase of the reboot of the node in the status of each
+// of requests, if it changed.
+func (rw *requestWatcher) track(ctx context.Context, requests []*rebootrequest.NodeRebootRequest, node *v1.Node, phase rebootrequest.Phase, message string) {
+	for _, request := range requests {
+		if current, ok := request.Status.Nodes[node.Name]; ok && current.Phase == phase && current.Message == message {
+			continue
+		}
+		if err := rw.setNodeStatus(ctx, request.Name, node, phase, message); err != nil {
+			log.Warnf("Error updating status of NodeRebootRequest %s: %v", request.Name, err)
+			continue
+		}
+		if phase.Final() {
+			rw.finished[request.UID] = true
+		}
+		if message != "" {
+			log.Infof("NodeRebootRequest %s: %s (%s)", request.Name, phase, message)
+		} else {
+			log.Infof("NodeRebootRequest %s: %s", request.Name, phase)
+		}
+	}
+}
+
+// setNodeStatus writes the status of the reboot of the node into a request,
+// updating the summary of the request.
+func (rw *requestWatcher) setNodeStatus(ctx context.Context, name string, node *v1.Node, phase rebootrequest.Phase, message string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		u, err := rw.client.Resource(rebootrequest.Resource).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		request, err := requestFromUnstructured(u)
+		if err != nil {
+			return err
+		}
+
+		if request.Status.Nodes == nil {
+			request.Status.Nodes = map[string]rebootrequest.NodeStatus{}
+		}
+		request.Status.Nodes[node.Name] = rebootrequest.NodeStatus{
+			Phase:              phase,
+			Message:            message,
+			BootID:             node.Status.NodeInfo.BootID,
+			LastTransitionTime: metav1.Now(),
+		}
+		targets, err := rw.targets(ctx, request)
+		if err != nil {
+			return err
+		}
+		request.Status.Phase, request.Status.Message = rebootrequest.Summarize(request.Status.Nodes, targets)
+		return rw.updateStatus(ctx, u, request)
+	})
+}
+
+// recordTargets records the nodes currently matching the selector of a
+// request as its targets, unless another kured pod did so first, and
+// returns the request with its targets.
+func (rw *requestWatcher) recordTargets(ctx context.Context, name string) (*rebootrequest.NodeRebootRequest, error) {
+	var recorded *rebootrequest.NodeRebootRequest
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		u, err := rw.client.Resource(rebootrequest.Resource).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		request, err := requestFromUnstructured(u)
+		if err != nil {
+			return err
+		}
+		if !request.NeedsTargets() {
+			recorded = request
+			return nil
+		}
+
+		names, err := rw.selected(ctx, request)
+		if err != nil {
+			return err
+		}
+		request.Status.TargetNodes = names
+		if len(names) == 0 {
+			request.Status.Phase, request.Status.Message = rebootrequest.Completed, "no nodes match the selector"
+		} else {
+			request.Status.Phase, request.Status.Message = rebootrequest.Summarize(request.Status.Nodes, len(names))
+		}
+		if err := rw.updateStatus(ctx, u, request); err != nil {
+			return err
+		}
+		log.Infof("NodeRebootRequest %s targets nodes %v", request.Name, names)
+		recorded = request
+		return nil
+	})
+	return recorded, err
+}
+
+// updateStatus writes the status of request into u.
+func (rw *requestWatcher) updateStatus(ctx context.Context, u *unstructured.Unstructured, request *rebootrequest.NodeRebootRequest) error {
+	data, err := json.Marshal(request.Status)
+	if err != nil {
+		return err
+	}
+	value := map[string]interface{}{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	u.Object["status"] = value
+	_, err = rw.client.Resource(rebootrequest.Resource).UpdateStatus(ctx, u, metav1.UpdateOptions{})
+	return err
+}
+
+// targets counts the nodes a request applies to.
+func (rw *requestWatcher) targets(ctx context.Context, request *rebootrequest.NodeRebootRequest) (int, error) {
+	if request.Spec.NodeName != "" {
+		return 1, nil
+	}
+	if request.Status.TargetNodes != nil {
+		return len(request.Status.TargetNodes), nil
+	}
+	names, err := rw.selected(ctx, request)
+	return len(names), err
+}
+
+// selected returns the names of the nodes currently matching the selector
+// of a request, ordered by name.
+func (rw *requestWatcher) selected(ctx context.Context, request *rebootrequest.NodeRebootRequest) ([]string, error) {
+	names := []string{}
+	if request.Spec.NodeSelector == nil {
+		return names, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(request.Spec.NodeSelector)
+	if err != nil {
+		return nil, err
+	}
+	nodeList, err := rw.nodes.CoreV1().Nodes().List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+	for _, node := range nodeList.Items {
+		names = append(names, node.Name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// requestFromUnstructured converts a NodeRebootRequest retrieved via the
+// dynamic client.
+func requestFromUnstructured(u *unstructured.Unstructured) (*rebootrequest.NodeRebootRequest, error) {
+	data, err := u.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	request := &rebootrequest.NodeRebootRequest{}
+	if err := json.Unmarshal(data, request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
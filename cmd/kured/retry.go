@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/weaveworks/kured/pkg/errorclass"
+)
+
+// retryBackoff spaces out retries of API operations failing transiently,
+// giving up after about a minute.
+var retryBackoff = wait.Backoff{
+	Duration: time.Second,
+	Factor:   2,
+	Jitter:   0.1,
+	Steps:    6,
+	Cap:      30 * time.Second,
+}
+
+// maxConflictRetries limits how often an operation is retried right away
+// after conflicting with concurrent updates.
+const maxConflictRetries = 10
+
+// withRetries runs op, which must read anew whatever it updates. Conflicts
+// are retried right away, transient errors with backoff, until op succeeds,
+// fails fatally, retries are exhausted or ctx is done; the last error is
+// returned then. Every error is counted by class.
+func withRetries(ctx context.Context, what string, op func() error) error {
+	backoff := retryBackoff
+	conflicts := 0
+	for {
+		err := op()
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+
+		class := countError(err)
+		switch {
+		case class == errorclass.Conflict && conflicts < maxConflictRetries:
+			conflicts++
+			log.Infof("Conflict %s, retrying: %v", what, err)
+			continue
+		case class == errorclass.Transient && backoff.Steps > 0:
+			delay := backoff.Step()
+			log.Warnf("Error %s, retrying in %v: %v", what, delay.Round(time.Second), err)
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(delay):
+			}
+		default:
+			return err
+		}
+	}
+}
+
+// countError counts err in the error metric, returning its class. Errors
+// from shutting down are not counted.
+func countError(err error) errorclass.Class {
+	if errors.Is(err, context.Canceled) {
+		return ""
+	}
+	class := errorclass.Classify(err)
+	errorsCounter.WithLabelValues(string(class)).Inc()
+	return class
+}
+
+// fatal determines whether err is fatal, rather than an error which
+// retrying later may resolve, or the result of shutting down.
+func fatal(err error) bool {
+	return !errors.Is(err, context.Canceled) && errorclass.Classify(err) == errorclass.Fatal
+}
+
+// giveUp deals with an error retries did not resolve: kured exits on fatal
+// errors, and otherwise leaves the operation to be tried again later.
+func giveUp(err error) {
+	if fatal(err) {
+		log.Fatal(err)
+	}
+	log.Warn(err)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/weaveworks/kured/pkg/timewindow"
+)
+
+// scheduleHorizon limits how far ahead reboot windows are looked for.
+const scheduleHorizon = 366 * 24 * time.Hour
+
+var (
+	scheduleCount int
+	scheduleFrom  string
+
+	// currentWindow holds the timewindow.Window currently applying to this
+	// node, including any per-node override.
+	currentWindow atomic.Value
+)
+
+// newScheduleCommand creates the schedule subcommand, which prints the
+// upcoming reboot windows resulting from the flags and configuration file.
+func newScheduleCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "schedule",
+		Short: "Print the upcoming reboot windows",
+		Long: "Print the upcoming reboot windows resulting from the given flags and configuration file. " +
+			"Per-node window annotations are not taken into account.",
+		Args: cobra.NoArgs,
+		RunE: printSchedule}
+
+	cmd.Flags().IntVar(&scheduleCount, "count", 10,
+		"number of reboot windows to print")
+	cmd.Flags().StringVar(&scheduleFrom, "from", "",
+		"print reboot windows from this time, given in RFC 3339 format (default: now)")
+
+	return cmd
+}
+
+func printSchedule(cmd *cobra.Command, args []string) error {
+	from := time.Now()
+	if scheduleFrom != "" {
+		var err error
+		if from, err = time.Parse(time.RFC3339, scheduleFrom); err != nil {
+			return err
+		}
+	}
+
+	cfg, err := loadConfig(configFile)
+	if err != nil {
+		return fmt.Errorf("Failed to load configuration: %v", err)
+	}
+	schedule, err := newRebootSchedule(cfg.Windows)
+	if err != nil {
+		return fmt.Errorf("Failed to build time window: %v", err)
+	}
+	blackouts, err := newBlackouts(cfg.Blackouts)
+	if err != nil {
+		return fmt.Errorf("Failed to load blackouts: %v", err)
+	}
+	schedule.Exclude(blackouts...)
+
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "Reboot on: %v\n", schedule)
+
+	periods := timewindow.Next(schedule, from, scheduleCount, scheduleHorizon)
+	if len(periods) == 0 {
+		fmt.Fprintf(out, "No reboot window within %v\n", scheduleHorizon)
+		return nil
+	}
+	for _, period := range periods {
+		if period.End.IsZero() {
+			fmt.Fprintf(out, "%s - still open at %s\n", period.Start.Format(time.RFC3339), from.Add(scheduleHorizon).Format(time.RFC3339))
+			continue
+		}
+		fmt.Fprintf(out, "%s - %s (%v)\n", period.Start.Format(time.RFC3339), period.End.Format(time.RFC3339), period.End.Sub(period.Start))
+	}
+	return nil
+}
+
+// nextWindowRecheck is how long the next opening of the reboot window is
+// remembered for the metric, unless it passes or the window changes first.
+const nextWindowRecheck = 24 * time.Hour
+
+// maintainNextWindowMetric periodically publishes how long it is until the
+// reboot window of this node next opens. Looking for the next opening is
+// expensive, so it is only done again once the opening found has passed,
+// the window changed or nextWindowRecheck elapsed.
+func maintainNextWindowMetric(nodeID string) {
+	var checked, next time.Time
+	var checkedWindow string
+	for {
+		if window, ok := currentWindow.Load().(timewindow.Window); ok {
+			now := time.Now()
+			if window.Contains(now) {
+				nextWindowGauge.WithLabelValues(nodeID).Set(0)
+			} else {
+				if window.String() != checkedWindow || now.Sub(checked) >= nextWindowRecheck || (!next.IsZero() && !now.Before(next)) {
+					checked, checkedWindow, next = now, window.String(), time.Time{}
+					if until, ok := timewindow.Until(window, now, scheduleHorizon); ok {
+						next = now.Add(until)
+					}
+				}
+				if !next.IsZero() {
+					nextWindowGauge.WithLabelValues(nodeID).Set(next.Sub(now).Seconds())
+				} else {
+					nextWindowGauge.DeleteLabelValues(nodeID)
+				}
+			}
+		}
+		time.Sleep(time.Minute)
+	}
+}
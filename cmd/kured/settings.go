@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/weaveworks/kured/pkg/blockers"
+	"github.com/weaveworks/kured/pkg/drainorder"
+	"github.com/weaveworks/kured/pkg/rollout"
+	"github.com/weaveworks/kured/pkg/timewindow"
+)
+
+// settings are what applies to a node, from the command line flags combined
+// with the configuration file or the RebootPolicy matching the node.
+type settings struct {
+	// source names where the settings come from, for logging.
+	source      string
+	schedule    *timewindow.Schedule
+	blackouts   []timewindow.Blackout
+	drain       drainOptions
+	notifiers   notifiers
+	concurrency int
+	// rollout is nil unless the nodes are rebooted in waves.
+	rollout *rollout.Plan
+	health  healthOptions
+}
+
+// healthOptions control the checks of a node after its reboot.
+type healthOptions struct {
+	// checks are the names of blockers which must not block.
+	checks  []string
+	timeout time.Duration
+}
+
+// drainOptions control how nodes are drained.
+type drainOptions struct {
+	tiers              []drainorder.Tier
+	classifier         drainorder.Classifier
+	gracePeriodSeconds int
+	timeout            time.Duration
+}
+
+// notifiers are where and how to announce drains and reboots.
+type notifiers struct {
+	slackHookURL          string
+	slackUsername         string
+	slackChannel          string
+	teamsHookURL          string
+	messageTemplateDrain  string
+	messageTemplateReboot string
+	// messageTemplateRolloutHalted takes the node and the reason.
+	messageTemplateRolloutHalted string
+}
+
+// newSettings combines the command line flags with cfg, whose source is
+// described by source. Blockers are not included, since they are specific
+// to a node.
+func newSettings(cfg *config, source string) (*settings, error) {
+	st := &settings{source: source, concurrency: cfg.Concurrency}
+
+	var err error
+	if st.schedule, err = newRebootSchedule(cfg.Windows); err != nil {
+		return nil, err
+	}
+	if st.blackouts, err = newBlackouts(cfg.Blackouts); err != nil {
+		return nil, err
+	}
+	st.schedule.Exclude(st.blackouts...)
+
+	order := drainOrder
+	st.drain = drainOptions{
+		classifier:         drainorder.Classifier{LastAnnotation: drainLastAnnotation, LastPriority: drainLastPriority},
+		gracePeriodSeconds: -1,
+	}
+	if d := cfg.Drain; d != nil {
+		if len(d.Order) > 0 {
+			order = d.Order
+		}
+		if d.LastAnnotation != "" {
+			st.drain.classifier.LastAnnotation = d.LastAnnotation
+		}
+		if d.LastPriority != nil {
+			st.drain.classifier.LastPriority = *d.LastPriority
+		}
+		if d.GracePeriodSeconds != nil {
+			st.drain.gracePeriodSeconds = *d.GracePeriodSeconds
+		}
+		st.drain.timeout = d.Timeout.Duration
+	}
+	if st.drain.tiers, err = drainorder.ParseTiers(order); err != nil {
+		return nil, err
+	}
+
+	st.notifiers = notifiers{
+		slackHookURL:          slackHookURL,
+		slackUsername:         slackUsername,
+		slackChannel:          slackChannel,
+		teamsHookURL:          teamsHookURL,
+		messageTemplateDrain:  messageTemplateDrain,
+		messageTemplateReboot: messageTemplateReboot,
+
+		messageTemplateRolloutHalted: messageTemplateRolloutHalted,
+	}
+	if n := cfg.Notifiers; n != nil {
+		override := func(value *string, configured string) {
+			if configured != "" {
+				*value = configured
+			}
+		}
+		override(&st.notifiers.slackHookURL, n.SlackHookURL)
+		override(&st.notifiers.slackUsername, n.SlackUsername)
+		override(&st.notifiers.slackChannel, n.SlackChannel)
+		override(&st.notifiers.teamsHookURL, n.TeamsHookURL)
+		override(&st.notifiers.messageTemplateDrain, n.MessageTemplateDrain)
+		override(&st.notifiers.messageTemplateReboot, n.MessageTemplateReboot)
+		override(&st.notifiers.messageTemplateRolloutHalted, n.MessageTemplateRolloutHalted)
+	}
+
+	if st.rollout, err = newRolloutPlan(cfg.Rollout); err != nil {
+		return nil, err
+	}
+	st.health.timeout = defaultHealthTimeout
+	if r := cfg.Rollout; r != nil {
+		st.health.checks = r.HealthChecks
+		if r.HealthTimeout.Duration > 0 {
+			st.health.timeout = r.HealthTimeout.Duration
+		}
+	}
+
+	return st, nil
+}
+
+// defaultHealthTimeout is how long a node has to become healthy after its
+// reboot, unless configured otherwise.
+const defaultHealthTimeout = 10 * time.Minute
+
+// nodeSettings keeps the settings and reboot blockers applying to a node up
+// to date with the RebootPolicies.
+type nodeSettings struct {
+	client    kubernetes.Interface
+	nodeID    string
+	rebooting blockers.Rebooting
+	cfg       *config
+	// policies is nil unless RebootPolicies are watched.
+	policies *policyWatcher
+
+	key      string
+	settings *settings
+	registry *blockers.Registry
+}
+
+// update resolves the settings applying to the node, rebuilding them if the
+// matching RebootPolicy changed. Invalid settings are reported, leaving the
+// previous ones in place.
+func (ns *nodeSettings) update(node *v1.Node) error {
+	var policy *rebootPolicy
+	if ns.policies != nil {
+		policy = ns.policies.match(node)
+	}
+	key := ""
+	if policy != nil {
+		key = policy.Name + "@" + policy.ResourceVersion
+	}
+	if ns.settings != nil && key == ns.key {
+		return nil
+	}
+
+	st, cfg, err := policySettings(policy, ns.cfg)
+	if err != nil {
+		return err
+	}
+	registry, err := newBlockerRegistry(ns.client, ns.nodeID, ns.rebooting, cfg.Blockers, st.rollout)
+	if err != nil {
+		return fmt.Errorf("Failed to configure reboot blockers from %s: %v", st.source, err)
+	}
+	if err := checkHealthChecks(registry, st.health.checks); err != nil {
+		return fmt.Errorf("Invalid rollout in %s: %v", st.source, err)
+	}
+	ns.key, ns.settings, ns.registry = key, st, registry
+
+	log.Infof("Using settings from %s", st.source)
+	log.Infof("Reboot blockers: %v", registry.Names())
+	return nil
+}
+
+// checkHealthChecks ensures that the health checks name blockers of the
+// registry, other than the rollout itself.
+func checkHealthChecks(registry *blockers.Registry, checks []string) error {
+	names := map[string]bool{}
+	for _, name := range registry.Names() {
+		names[name] = true
+	}
+	for _, check := range checks {
+		if !names[check] || check == "rollout" {
+			return fmt.Errorf("Unknown health check %q, must name a reboot blocker", check)
+		}
+	}
+	return nil
+}
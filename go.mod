@@ -12,4 +12,5 @@ require (
 	k8s.io/apimachinery v0.19.4
 	k8s.io/client-go v0.19.4
 	k8s.io/kubectl v0.19.4
+	sigs.k8s.io/yaml v1.2.0
 )
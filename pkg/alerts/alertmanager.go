@@ -0,0 +1,76 @@
+package alerts
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+var (
+	httpClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+// alertmanagerAlert is the subset of the Alertmanager v2 API alert representation used here.
+type alertmanagerAlert struct {
+	Labels map[string]string `json:"labels"`
+	Status struct {
+		State string `json:"state"`
+	} `json:"status"`
+}
+
+// AlertmanagerActiveAlerts returns a list of names of active alerts known to the Alertmanager at
+// alertmanagerURL, filtered by the supplied filter. Silenced and inhibited alerts are not
+// considered active. Alertmanager only receives firing alerts, so all of them count as firing.
+func AlertmanagerActiveAlerts(ctx context.Context, alertmanagerURL string, filter *Filter) ([]string, error) {
+	query := url.Values{}
+	query.Set("active", "true")
+	query.Set("silenced", "false")
+	query.Set("inhibited", "false")
+	query.Set("unprocessed", "true")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(alertmanagerURL, "/")+"/api/v2/alerts?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf(resp.Status)
+	}
+
+	var alerts []alertmanagerAlert
+	if err := json.NewDecoder(resp.Body).Decode(&alerts); err != nil {
+		return nil, err
+	}
+
+	activeAlertSet := make(map[string]bool)
+	for _, alert := range alerts {
+		if alert.Status.State == "suppressed" {
+			continue
+		}
+		alertName, isAlert := alert.Labels[string(model.AlertNameLabel)]
+		if isAlert && filter.Matches(alert.Labels, true) {
+			activeAlertSet[alertName] = true
+		}
+	}
+
+	var activeAlerts []string
+	for activeAlert := range activeAlertSet {
+		activeAlerts = append(activeAlerts, activeAlert)
+	}
+	sort.Sort(sort.StringSlice(activeAlerts))
+
+	return activeAlerts, nil
+}
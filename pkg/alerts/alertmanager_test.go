@@ -0,0 +1,66 @@
+package alerts
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+const alertmanagerResponse = `[
+	{"labels":{"alertname":"NodeDown","severity":"critical","node":"a"},"status":{"state":"active","silencedBy":[],"inhibitedBy":[]}},
+	{"labels":{"alertname":"NodeDown","severity":"critical","node":"b"},"status":{"state":"active","silencedBy":[],"inhibitedBy":[]}},
+	{"labels":{"alertname":"Watchdog","severity":"none"},"status":{"state":"active","silencedBy":[],"inhibitedBy":[]}},
+	{"labels":{"alertname":"DiskFull","severity":"warning"},"status":{"state":"suppressed","silencedBy":["123"],"inhibitedBy":[]}}
+]`
+
+func TestAlertmanagerActiveAlerts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/alerts" {
+			http.NotFound(w, r)
+			return
+		}
+		query := r.URL.Query()
+		if query.Get("active") != "true" || query.Get("silenced") != "false" || query.Get("inhibited") != "false" {
+			http.Error(w, fmt.Sprintf("unexpected query: %s", r.URL.RawQuery), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, alertmanagerResponse)
+	}))
+	defer server.Close()
+
+	critical, err := ParseMatchers(`severity="critical"`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		filter *Filter
+		result []string
+	}{
+		{nil, []string{"NodeDown", "Watchdog"}},
+		{&Filter{FiringOnly: true}, []string{"NodeDown", "Watchdog"}},
+		{&Filter{Include: []Matchers{critical}}, []string{"NodeDown"}},
+		{&Filter{Ignore: []Matchers{critical}}, []string{"Watchdog"}},
+	}
+
+	for i, tst := range tests {
+		result, err := AlertmanagerActiveAlerts(context.Background(), server.URL+"/", tst.filter)
+		if err != nil {
+			t.Errorf("Test %d: unexpected error: %v", i, err)
+		} else if !reflect.DeepEqual(result, tst.result) {
+			t.Errorf("Test %d: expected %v got %v", i, tst.result, result)
+		}
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	if _, err := AlertmanagerActiveAlerts(context.Background(), failing.URL, nil); err == nil {
+		t.Errorf("Expected error from failing alertmanager")
+	}
+}
@@ -0,0 +1,228 @@
+package alerts
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/common/model"
+)
+
+// MatchType is the comparison performed by a Matcher.
+type MatchType string
+
+// Supported match types, following the Prometheus selector syntax.
+const (
+	MatchEqual     MatchType = "="
+	MatchNotEqual  MatchType = "!="
+	MatchRegexp    MatchType = "=~"
+	MatchNotRegexp MatchType = "!~"
+)
+
+// Matcher compares a single label against a value.
+type Matcher struct {
+	Name  string
+	Type  MatchType
+	Value string
+	re    *regexp.Regexp
+}
+
+// NewMatcher creates a matcher. Regular expressions are fully anchored, as
+// they are in Prometheus.
+func NewMatcher(name string, t MatchType, value string) (*Matcher, error) {
+	m := &Matcher{Name: name, Type: t, Value: value}
+	switch t {
+	case MatchEqual, MatchNotEqual:
+	case MatchRegexp, MatchNotRegexp:
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return nil, err
+		}
+		m.re = re
+	default:
+		return nil, fmt.Errorf("Invalid match type: %s", t)
+	}
+	return m, nil
+}
+
+// Matches returns true if the label value satisfies the matcher. Missing
+// labels are treated as empty.
+func (m *Matcher) Matches(value string) bool {
+	switch m.Type {
+	case MatchEqual:
+		return value == m.Value
+	case MatchNotEqual:
+		return value != m.Value
+	case MatchRegexp:
+		return m.re.MatchString(value)
+	case MatchNotRegexp:
+		return !m.re.MatchString(value)
+	}
+	return false
+}
+
+// String returns the matcher in selector syntax.
+func (m *Matcher) String() string {
+	return fmt.Sprintf("%s%s%q", m.Name, m.Type, m.Value)
+}
+
+// Matchers is a set of matchers which must all match.
+type Matchers []*Matcher
+
+// Matches returns true if all matchers match the labels.
+func (ms Matchers) Matches(labels map[string]string) bool {
+	for _, m := range ms {
+		if !m.Matches(labels[m.Name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the matchers in selector syntax.
+func (ms Matchers) String() string {
+	parts := make([]string, 0, len(ms))
+	for _, m := range ms {
+		parts = append(parts, m.String())
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// ParseMatchers parses a comma separated list of label matchers in
+// Prometheus selector syntax, e.g. `severity=~"critical|page",namespace!="dev"`.
+// The surrounding braces and the quotes around values are optional.
+func ParseMatchers(s string) (Matchers, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+		s = s[1 : len(s)-1]
+	}
+
+	var ms Matchers
+	for {
+		s = strings.TrimLeft(s, " ,")
+		if s == "" {
+			break
+		}
+
+		name := labelName(s)
+		if name == "" {
+			return nil, fmt.Errorf("Invalid label name in matcher: %s", s)
+		}
+		s = strings.TrimLeft(s[len(name):], " ")
+
+		var t MatchType
+		for _, candidate := range []MatchType{MatchRegexp, MatchNotRegexp, MatchNotEqual, MatchEqual} {
+			if strings.HasPrefix(s, string(candidate)) {
+				t = candidate
+				break
+			}
+		}
+		if t == "" {
+			return nil, fmt.Errorf("Invalid operator in matcher for label %s: %s", name, s)
+		}
+		s = strings.TrimLeft(s[len(t):], " ")
+
+		value, rest, err := matcherValue(s)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid value in matcher for label %s: %v", name, err)
+		}
+		s = strings.TrimLeft(rest, " ")
+		if s != "" && s[0] != ',' {
+			return nil, fmt.Errorf("Expected ',' after matcher for label %s: %s", name, s)
+		}
+
+		m, err := NewMatcher(name, t, value)
+		if err != nil {
+			return nil, err
+		}
+		ms = append(ms, m)
+	}
+
+	if len(ms) == 0 {
+		return nil, fmt.Errorf("No matchers given")
+	}
+	return ms, nil
+}
+
+// labelName returns the label name at the beginning of s.
+func labelName(s string) string {
+	for i, c := range s {
+		if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9') {
+			continue
+		}
+		return s[:i]
+	}
+	return s
+}
+
+// matcherValue returns the quoted or unquoted value at the beginning of s
+// and the remainder of s.
+func matcherValue(s string) (string, string, error) {
+	if !strings.HasPrefix(s, `"`) {
+		if i := strings.Index(s, ","); i >= 0 {
+			return strings.TrimSpace(s[:i]), s[i:], nil
+		}
+		return strings.TrimSpace(s), "", nil
+	}
+
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			value, err := strconv.Unquote(s[:i+1])
+			return value, s[i+1:], err
+		}
+	}
+	return "", "", fmt.Errorf("Unterminated quoted value: %s", s)
+}
+
+// Filter selects the alerts which are considered active.
+type Filter struct {
+	// NameRegexp ignores alerts whose name matches.
+	NameRegexp *regexp.Regexp
+	// Include only considers alerts matching any of these sets, if given.
+	Include []Matchers
+	// Ignore drops alerts matching any of these sets.
+	Ignore []Matchers
+	// FiringOnly drops alerts which are still pending.
+	FiringOnly bool
+}
+
+// Matches returns true if an alert with the given labels and state passes
+// the filter. A nil filter matches every alert.
+func (f *Filter) Matches(labels map[string]string, firing bool) bool {
+	if f == nil {
+		return true
+	}
+
+	if f.FiringOnly && !firing {
+		return false
+	}
+
+	if f.NameRegexp != nil && f.NameRegexp.MatchString(labels[string(model.AlertNameLabel)]) {
+		return false
+	}
+
+	if len(f.Include) > 0 {
+		included := false
+		for _, ms := range f.Include {
+			if ms.Matches(labels) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+
+	for _, ms := range f.Ignore {
+		if ms.Matches(labels) {
+			return false
+		}
+	}
+
+	return true
+}
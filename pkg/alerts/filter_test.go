@@ -0,0 +1,89 @@
+package alerts
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestParseMatchers(t *testing.T) {
+	tests := []struct {
+		input  string
+		result string
+	}{
+		{`severity="critical"`, `{severity="critical"}`},
+		{`{severity=~"critical|page", namespace!="dev"}`, `{severity=~"critical|page",namespace!="dev"}`},
+		{`severity!~info,team=ops`, `{severity!~"info",team="ops"}`},
+		{`msg="a, \"quoted\" value"`, `{msg="a, \"quoted\" value"}`},
+		{`a="",b=""`, `{a="",b=""}`},
+	}
+
+	for _, tst := range tests {
+		ms, err := ParseMatchers(tst.input)
+		if err != nil {
+			t.Errorf("Received error for input %s: %v", tst.input, err)
+		} else if ms.String() != tst.result {
+			t.Errorf("Test %s: Expected %s got %s", tst.input, tst.result, ms.String())
+		}
+	}
+}
+
+func TestParseMatchersErrors(t *testing.T) {
+	tests := []string{
+		``,
+		`{}`,
+		`severity`,
+		`severity~"x"`,
+		`severity="unterminated`,
+		`severity="a" team="b"`,
+		`severity=~"("`,
+		`1abc="x"`,
+	}
+
+	for _, tst := range tests {
+		if _, err := ParseMatchers(tst); err == nil {
+			t.Errorf("Expected to receive error for input %s", tst)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	mustParse := func(s string) Matchers {
+		ms, err := ParseMatchers(s)
+		if err != nil {
+			t.Fatalf("Received error for input %s: %v", s, err)
+		}
+		return ms
+	}
+
+	critical := map[string]string{"alertname": "NodeDown", "severity": "critical", "namespace": "prod"}
+	devCritical := map[string]string{"alertname": "NodeDown", "severity": "critical", "namespace": "dev"}
+	info := map[string]string{"alertname": "Watchdog", "severity": "none"}
+
+	tests := []struct {
+		name   string
+		filter *Filter
+		labels map[string]string
+		firing bool
+		result bool
+	}{
+		{"nil filter", nil, info, false, true},
+		{"empty filter", &Filter{}, info, false, true},
+		{"name regexp", &Filter{NameRegexp: regexp.MustCompile("^Watchdog$")}, info, true, false},
+		{"firing only pending", &Filter{FiringOnly: true}, critical, false, false},
+		{"firing only firing", &Filter{FiringOnly: true}, critical, true, true},
+		{"include match", &Filter{Include: []Matchers{mustParse(`severity=~"critical|page"`)}}, critical, true, true},
+		{"include miss", &Filter{Include: []Matchers{mustParse(`severity=~"critical|page"`)}}, info, true, false},
+		{"include any", &Filter{Include: []Matchers{mustParse(`severity="page"`), mustParse(`alertname="Watchdog"`)}}, info, true, true},
+		{"ignore", &Filter{Ignore: []Matchers{mustParse(`namespace="dev"`)}}, devCritical, true, false},
+		{"ignore missing label", &Filter{Ignore: []Matchers{mustParse(`namespace!="prod"`)}}, info, true, false},
+		{"include and ignore", &Filter{
+			Include: []Matchers{mustParse(`severity="critical"`)},
+			Ignore:  []Matchers{mustParse(`namespace="dev"`)}}, critical, true, true},
+	}
+
+	for _, tst := range tests {
+		if result := tst.filter.Matches(tst.labels, tst.firing); result != tst.result {
+			t.Errorf("Test %s: Expected %v got %v", tst.name, tst.result, result)
+		}
+	}
+}
@@ -3,7 +3,6 @@ package alerts
 import (
 	"context"
 	"fmt"
-	"regexp"
 	"sort"
 	"time"
 
@@ -12,9 +11,12 @@ import (
 	"github.com/prometheus/common/model"
 )
 
+// alertStateLabel is the label of the ALERTS metric holding the alert state.
+const alertStateLabel = "alertstate"
+
 // PrometheusActiveAlerts returns a list of names of active (e.g. pending or firing) alerts, filtered
-// by the supplied regexp.
-func PrometheusActiveAlerts(prometheusURL string, filter *regexp.Regexp) ([]string, error) {
+// by the supplied filter.
+func PrometheusActiveAlerts(ctx context.Context, prometheusURL string, filter *Filter) ([]string, error) {
 	client, err := api.NewClient(api.Config{Address: prometheusURL})
 	if err != nil {
 		return nil, err
@@ -22,7 +24,7 @@ func PrometheusActiveAlerts(prometheusURL string, filter *regexp.Regexp) ([]stri
 
 	queryAPI := v1.NewAPI(client)
 
-	value, _, err := queryAPI.Query(context.Background(), "ALERTS", time.Now())
+	value, _, err := queryAPI.Query(ctx, "ALERTS", time.Now())
 	if err != nil {
 		return nil, err
 	}
@@ -32,7 +34,11 @@ func PrometheusActiveAlerts(prometheusURL string, filter *regexp.Regexp) ([]stri
 			activeAlertSet := make(map[string]bool)
 			for _, sample := range vector {
 				if alertName, isAlert := sample.Metric[model.AlertNameLabel]; isAlert && sample.Value != 0 {
-					if filter == nil || !filter.MatchString(string(alertName)) {
+					labels := make(map[string]string, len(sample.Metric))
+					for name, value := range sample.Metric {
+						labels[string(name)] = string(value)
+					}
+					if filter.Matches(labels, sample.Metric[alertStateLabel] == "firing") {
 						activeAlertSet[string(alertName)] = true
 					}
 				}
@@ -50,3 +56,28 @@ func PrometheusActiveAlerts(prometheusURL string, filter *regexp.Regexp) ([]stri
 
 	return nil, fmt.Errorf("Unexpected value type: %v", value)
 }
+
+// PrometheusQuery evaluates the PromQL expression query at the current time and returns the
+// resulting samples. Scalar results are returned as a single sample without labels.
+func PrometheusQuery(ctx context.Context, prometheusURL, query string) (model.Vector, error) {
+	client, err := api.NewClient(api.Config{Address: prometheusURL})
+	if err != nil {
+		return nil, err
+	}
+
+	queryAPI := v1.NewAPI(client)
+
+	value, _, err := queryAPI.Query(ctx, query, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	switch value := value.(type) {
+	case model.Vector:
+		return value, nil
+	case *model.Scalar:
+		return model.Vector{&model.Sample{Metric: model.Metric{}, Value: value.Value, Timestamp: value.Timestamp}}, nil
+	}
+
+	return nil, fmt.Errorf("Unexpected value type: %v", value)
+}
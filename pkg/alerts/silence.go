@@ -0,0 +1,93 @@
+package alerts
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// silenceMatcher is the Alertmanager v2 API representation of a matcher.
+type silenceMatcher struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	IsRegex bool   `json:"isRegex"`
+}
+
+type silence struct {
+	Matchers  []silenceMatcher `json:"matchers"`
+	StartsAt  time.Time        `json:"startsAt"`
+	EndsAt    time.Time        `json:"endsAt"`
+	CreatedBy string           `json:"createdBy"`
+	Comment   string           `json:"comment"`
+}
+
+type silenceResponse struct {
+	SilenceID string `json:"silenceID"`
+}
+
+// CreateSilence creates a silence in the Alertmanager at alertmanagerURL for alerts matching all
+// matchers, lasting for duration from now on. Only equality and regexp matchers are supported.
+// It returns the ID of the new silence.
+func CreateSilence(alertmanagerURL string, matchers Matchers, duration time.Duration, createdBy, comment string) (string, error) {
+	now := time.Now().UTC()
+	s := silence{StartsAt: now, EndsAt: now.Add(duration), CreatedBy: createdBy, Comment: comment}
+	for _, m := range matchers {
+		switch m.Type {
+		case MatchEqual:
+			s.Matchers = append(s.Matchers, silenceMatcher{Name: m.Name, Value: m.Value})
+		case MatchRegexp:
+			s.Matchers = append(s.Matchers, silenceMatcher{Name: m.Name, Value: m.Value, IsRegex: true})
+		default:
+			return "", fmt.Errorf("Unsupported silence matcher: %v", m)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(&s); err != nil {
+		return "", err
+	}
+
+	resp, err := httpClient.Post(strings.TrimSuffix(alertmanagerURL, "/")+"/api/v2/silences", "application/json", &buf)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf(resp.Status)
+	}
+
+	var created silenceResponse
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return "", err
+	}
+	if created.SilenceID == "" {
+		return "", fmt.Errorf("No silence ID returned")
+	}
+
+	return created.SilenceID, nil
+}
+
+// ExpireSilence expires the silence with the given ID in the Alertmanager at alertmanagerURL.
+func ExpireSilence(alertmanagerURL, id string) error {
+	req, err := http.NewRequest(http.MethodDelete, strings.TrimSuffix(alertmanagerURL, "/")+"/api/v2/silence/"+url.PathEscape(id), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf(resp.Status)
+	}
+
+	return nil
+}
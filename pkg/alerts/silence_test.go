@@ -0,0 +1,70 @@
+package alerts
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSilences(t *testing.T) {
+	var created silence
+	var expired string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v2/silences":
+			if err := json.NewDecoder(r.Body).Decode(&created); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"silenceID":"abc-123"}`)
+		case r.Method == http.MethodDelete && r.URL.Path == "/api/v2/silence/abc-123":
+			expired = "abc-123"
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	matchers, err := ParseMatchers(`node="node-1",instance=~"node-1(:[0-9]+)?"`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	id, err := CreateSilence(server.URL, matchers, time.Hour, "kured", "Rebooting node-1")
+	if err != nil {
+		t.Fatalf("Unexpected error creating silence: %v", err)
+	}
+	if id != "abc-123" {
+		t.Errorf("Expected silence ID abc-123, got %s", id)
+	}
+	if len(created.Matchers) != 2 ||
+		created.Matchers[0] != (silenceMatcher{Name: "node", Value: "node-1"}) ||
+		created.Matchers[1] != (silenceMatcher{Name: "instance", Value: "node-1(:[0-9]+)?", IsRegex: true}) {
+		t.Errorf("Unexpected silence matchers: %v", created.Matchers)
+	}
+	if d := created.EndsAt.Sub(created.StartsAt); d != time.Hour {
+		t.Errorf("Expected silence to last 1h, got %v", d)
+	}
+	if created.CreatedBy != "kured" || created.Comment != "Rebooting node-1" {
+		t.Errorf("Unexpected silence author or comment: %s, %s", created.CreatedBy, created.Comment)
+	}
+
+	if err := ExpireSilence(server.URL, id); err != nil {
+		t.Errorf("Unexpected error expiring silence: %v", err)
+	}
+	if expired != id {
+		t.Errorf("Expected silence %s to be expired", id)
+	}
+	if err := ExpireSilence(server.URL, "unknown"); err == nil {
+		t.Errorf("Expected error expiring unknown silence")
+	}
+
+	notEqual, _ := ParseMatchers(`node!="node-1"`)
+	if _, err := CreateSilence(server.URL, notEqual, time.Hour, "kured", ""); err == nil {
+		t.Errorf("Expected error creating silence with negative matcher")
+	}
+}
@@ -0,0 +1,36 @@
+package blockers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/weaveworks/kured/pkg/alerts"
+)
+
+// AlertmanagerBlocker blocks reboots while Alertmanager holds active alerts
+// which are neither silenced nor inhibited.
+type AlertmanagerBlocker struct {
+	name            string
+	alertmanagerURL string
+	filter          *alerts.Filter
+}
+
+// NewAlertmanagerBlocker creates a blocker querying the Alertmanager at
+// alertmanagerURL for active alerts passing filter.
+func NewAlertmanagerBlocker(name, alertmanagerURL string, filter *alerts.Filter) *AlertmanagerBlocker {
+	return &AlertmanagerBlocker{name: name, alertmanagerURL: alertmanagerURL, filter: filter}
+}
+
+// Name implements Blocker.
+func (ab *AlertmanagerBlocker) Name() string {
+	return ab.name
+}
+
+// IsBlocked implements Blocker.
+func (ab *AlertmanagerBlocker) IsBlocked(ctx context.Context) (bool, string) {
+	alertNames, err := alerts.AlertmanagerActiveAlerts(ctx, ab.alertmanagerURL, ab.filter)
+	if err != nil {
+		return true, fmt.Sprintf("alertmanager query error: %v", err)
+	}
+	return activeAlertsBlocked(alertNames)
+}
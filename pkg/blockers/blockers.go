@@ -0,0 +1,93 @@
+package blockers
+
+import (
+	"context"
+	"fmt"
+)
+
+// Blocker is a condition which, while present, prevents a node from rebooting.
+type Blocker interface {
+	// Name identifies the blocker in logs and metrics.
+	Name() string
+	// IsBlocked returns true and a human readable reason if reboots must not
+	// proceed. Blockers which cannot determine their state should err on the
+	// side of caution and report themselves as blocked.
+	IsBlocked(ctx context.Context) (blocked bool, reason string)
+}
+
+// Result holds the outcome of checking a single blocker.
+type Result struct {
+	Name    string
+	Blocked bool
+	Reason  string
+}
+
+// Registry holds the set of blockers consulted before each reboot.
+type Registry struct {
+	blockers []Blocker
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds a blocker to the registry. Blocker names must be unique.
+func (r *Registry) Register(b Blocker) error {
+	for _, existing := range r.blockers {
+		if existing.Name() == b.Name() {
+			return fmt.Errorf("Duplicate blocker name: %s", b.Name())
+		}
+	}
+	r.blockers = append(r.blockers, b)
+	return nil
+}
+
+// Names returns the names of all registered blockers in registration order.
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.blockers))
+	for _, b := range r.blockers {
+		names = append(names, b.Name())
+	}
+	return names
+}
+
+// Check consults every registered blocker in registration order. All
+// blockers are evaluated, even once one of them reports a block, so that
+// the state of each one can be reported.
+func (r *Registry) Check(ctx context.Context) []Result {
+	results := make([]Result, 0, len(r.blockers))
+	for _, b := range r.blockers {
+		blocked, reason := b.IsBlocked(ctx)
+		results = append(results, Result{Name: b.Name(), Blocked: blocked, Reason: reason})
+	}
+	return results
+}
+
+// CheckNamed consults only the registered blockers named, in registration
+// order; the others are not evaluated at all.
+func (r *Registry) CheckNamed(ctx context.Context, names ...string) []Result {
+	named := make(map[string]bool, len(names))
+	for _, name := range names {
+		named[name] = true
+	}
+	var results []Result
+	for _, b := range r.blockers {
+		if !named[b.Name()] {
+			continue
+		}
+		blocked, reason := b.IsBlocked(ctx)
+		results = append(results, Result{Name: b.Name(), Blocked: blocked, Reason: reason})
+	}
+	return results
+}
+
+// Blocked returns true if any of the results is blocked.
+func Blocked(results []Result) bool {
+	for _, result := range results {
+		if result.Blocked {
+			return true
+		}
+	}
+	return false
+}
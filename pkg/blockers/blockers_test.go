@@ -0,0 +1,391 @@
+package blockers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/weaveworks/kured/pkg/alerts"
+)
+
+type staticBlocker struct {
+	name    string
+	blocked bool
+	checked int
+}
+
+func (sb *staticBlocker) Name() string {
+	return sb.name
+}
+
+func (sb *staticBlocker) IsBlocked(ctx context.Context) (bool, string) {
+	sb.checked++
+	if sb.blocked {
+		return true, "static"
+	}
+	return false, ""
+}
+
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry()
+	if err := registry.Register(&staticBlocker{name: "a"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := registry.Register(&staticBlocker{name: "b", blocked: true}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := registry.Register(&staticBlocker{name: "c"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := registry.Register(&staticBlocker{name: "a", blocked: true}); err == nil {
+		t.Errorf("Expected error registering duplicate blocker name")
+	}
+
+	results := registry.Check(context.Background())
+	if len(results) != 3 {
+		t.Fatalf("Expected 3 results, got %d", len(results))
+	}
+	for i, expected := range []Result{{"a", false, ""}, {"b", true, "static"}, {"c", false, ""}} {
+		if results[i] != expected {
+			t.Errorf("Result %d: expected %v got %v", i, expected, results[i])
+		}
+	}
+	if !Blocked(results) {
+		t.Errorf("Expected results to be blocked")
+	}
+	if Blocked(results[:1]) {
+		t.Errorf("Expected results not to be blocked")
+	}
+	if Blocked(NewRegistry().Check(context.Background())) {
+		t.Errorf("Expected empty registry not to block")
+	}
+}
+
+func TestRegistryCheckNamed(t *testing.T) {
+	a, b, c := &staticBlocker{name: "a"}, &staticBlocker{name: "b", blocked: true}, &staticBlocker{name: "c", blocked: true}
+	registry := NewRegistry()
+	for _, blocker := range []*staticBlocker{a, b, c} {
+		if err := registry.Register(blocker); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	results := registry.CheckNamed(context.Background(), "c", "a", "unknown")
+	expected := []Result{{"a", false, ""}, {"c", true, "static"}}
+	if len(results) != len(expected) {
+		t.Fatalf("Expected %v got %v", expected, results)
+	}
+	for i := range expected {
+		if results[i] != expected[i] {
+			t.Errorf("Result %d: expected %v got %v", i, expected[i], results[i])
+		}
+	}
+	if b.checked != 0 {
+		t.Errorf("Expected blocker b not to be checked, was checked %d times", b.checked)
+	}
+	if len(registry.CheckNamed(context.Background())) != 0 || a.checked != 1 {
+		t.Errorf("Expected no blocker to be checked without names")
+	}
+}
+
+// newPrometheus starts a fake Prometheus server answering every query with
+// the supplied vector samples.
+func newPrometheus(samples ...string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":[%s]}}`, strings.Join(samples, ","))
+	}))
+}
+
+func alertSample(name, state string) string {
+	return fmt.Sprintf(`{"metric":{"__name__":"ALERTS","alertname":%q,"alertstate":%q},"value":[1600000000,"1"]}`, name, state)
+}
+
+func TestPrometheusBlocker(t *testing.T) {
+	server := newPrometheus(alertSample("RebootRequired", "firing"), alertSample("DiskFull", "pending"))
+	defer server.Close()
+
+	tests := []struct {
+		filter  *alerts.Filter
+		blocked bool
+		reason  string
+	}{
+		{nil, true, "2 active alerts: [DiskFull RebootRequired]"},
+		{&alerts.Filter{NameRegexp: regexp.MustCompile("^RebootRequired$")}, true, "1 active alerts: [DiskFull]"},
+		{&alerts.Filter{NameRegexp: regexp.MustCompile(".*")}, false, ""},
+		{&alerts.Filter{FiringOnly: true}, true, "1 active alerts: [RebootRequired]"},
+	}
+
+	for i, tst := range tests {
+		blocked, reason := NewPrometheusBlocker("prometheus", server.URL, tst.filter).IsBlocked(context.Background())
+		if blocked != tst.blocked || reason != tst.reason {
+			t.Errorf("Test %d: expected (%v, %q) got (%v, %q)", i, tst.blocked, tst.reason, blocked, reason)
+		}
+	}
+
+	server.Close()
+	if blocked, _ := NewPrometheusBlocker("prometheus", server.URL, nil).IsBlocked(context.Background()); !blocked {
+		t.Errorf("Expected unreachable prometheus to block")
+	}
+}
+
+func TestPodBlocker(t *testing.T) {
+	pod := func(namespace, name string, labels, annotations map[string]string, owner string, phase v1.PodPhase) *v1.Pod {
+		p := &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels, Annotations: annotations},
+			Spec:       v1.PodSpec{NodeName: "node-1"},
+			Status:     v1.PodStatus{Phase: phase},
+		}
+		if owner != "" {
+			p.OwnerReferences = []metav1.OwnerReference{{Kind: owner, Name: "owner"}}
+		}
+		return p
+	}
+	// Annotation values need not be valid label values
+	target := "s3://backups.example.com/databases/orders/" + strings.Repeat("x", 64) + " (nightly run)"
+	client := fake.NewSimpleClientset(
+		pod("databases", "orders", nil, map[string]string{"backup.example.com/target": target}, "", v1.PodRunning),
+		pod("batch", "job", map[string]string{"runtime": "long"}, nil, "Job", v1.PodRunning),
+		pod("batch", "done", map[string]string{"runtime": "long"}, nil, "Job", v1.PodSucceeded),
+		pod("default", "web", map[string]string{"app": "web"}, map[string]string{"backup": "running"}, "ReplicaSet", v1.PodRunning),
+	)
+
+	tests := []struct {
+		name      string
+		selectors []PodSelector
+		blocked   bool
+		reason    string
+	}{
+		{"labels", []PodSelector{{LabelSelector: "runtime=long"}}, true,
+			"2 pods matching runtime=long: [batch/done batch/job]"},
+		{"ignore completed", []PodSelector{{LabelSelector: "runtime=long", IgnoreCompleted: true}}, true,
+			"1 pods matching runtime=long running: [batch/job]"},
+		{"any selector", []PodSelector{{LabelSelector: "app=db"}, {LabelSelector: "app=web"}}, true,
+			"1 pods matching app=web: [default/web]"},
+		{"no match", []PodSelector{{LabelSelector: "app=db"}}, false, ""},
+		{"other namespace", []PodSelector{{LabelSelector: "runtime=long", Namespaces: []string{"default"}}}, false, ""},
+		{"namespace", []PodSelector{{LabelSelector: "runtime=long", Namespaces: []string{"default", "batch"}, IgnoreCompleted: true}}, true,
+			"1 pods matching runtime=long namespaces:[default batch] running: [batch/job]"},
+		{"annotations", []PodSelector{{AnnotationSelector: "backup=running"}}, true,
+			"1 pods matching annotations:backup=running: [default/web]"},
+		{"annotation value", []PodSelector{{AnnotationSelector: "backup.example.com/target = " + target + ", backup.example.com/target"}}, true,
+			"1 pods matching annotations:backup.example.com/target = " + target + ", backup.example.com/target: [databases/orders]"},
+		{"annotation present", []PodSelector{{AnnotationSelector: "backup.example.com/target"}}, true,
+			"1 pods matching annotations:backup.example.com/target: [databases/orders]"},
+		{"annotation value mismatch", []PodSelector{{AnnotationSelector: "backup.example.com/target=s3://backups.example.com/"}}, false, ""},
+		{"invalid annotation selector", []PodSelector{{AnnotationSelector: "backup=running,=x"}}, true,
+			`invalid annotation selector "backup=running,=x": Missing annotation key in "=x"`},
+		{"owner kind", []PodSelector{{OwnerKinds: []string{"Job"}, IgnoreCompleted: true}}, true,
+			"1 pods matching owners:[Job] running: [batch/job]"},
+		{"owner kind mismatch", []PodSelector{{OwnerKinds: []string{"StatefulSet"}}}, false, ""},
+		{"no selectors", nil, false, ""},
+	}
+
+	for _, tst := range tests {
+		blocked, reason := NewPodBlocker("pods", client, "node-1", tst.selectors).IsBlocked(context.Background())
+		if blocked != tst.blocked || reason != tst.reason {
+			t.Errorf("Test %s: expected (%v, %q) got (%v, %q)", tst.name, tst.blocked, tst.reason, blocked, reason)
+		}
+	}
+}
+
+func TestQueryBlocker(t *testing.T) {
+	sample := func(instance, value string) string {
+		return fmt.Sprintf(`{"metric":{"instance":%q},"value":[1600000000,%q]}`, instance, value)
+	}
+	one, three := 1.0, 3.0
+
+	tests := []struct {
+		samples   []string
+		threshold *float64
+		blocked   bool
+	}{
+		{nil, nil, false},
+		{[]string{sample("a", "0")}, nil, true},
+		{[]string{sample("a", "1"), sample("b", "2")}, &three, false},
+		{[]string{sample("a", "1"), sample("b", "2")}, &one, true},
+	}
+
+	for i, tst := range tests {
+		server := newPrometheus(tst.samples...)
+		blocked, reason := NewQueryBlocker("query", server.URL, "up == 0", tst.threshold).IsBlocked(context.Background())
+		if blocked != tst.blocked {
+			t.Errorf("Test %d: expected %v got %v (%s)", i, tst.blocked, blocked, reason)
+		}
+		server.Close()
+	}
+
+	scalar := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"status":"success","data":{"resultType":"scalar","result":[1600000000,"5"]}}`)
+	}))
+	defer scalar.Close()
+	if blocked, reason := NewQueryBlocker("scalar", scalar.URL, "scalar(x)", &three).IsBlocked(context.Background()); !blocked {
+		t.Errorf("Expected scalar above threshold to block (%s)", reason)
+	}
+}
+
+func TestPodDisruptionBudgetBlocker(t *testing.T) {
+	pod := func(name, node string, labels map[string]string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: labels},
+			Spec:       v1.PodSpec{NodeName: node},
+		}
+	}
+	pdb := func(name string, app string, allowed int32) *policyv1beta1.PodDisruptionBudget {
+		return &policyv1beta1.PodDisruptionBudget{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: policyv1beta1.PodDisruptionBudgetSpec{
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": app}}},
+			Status: policyv1beta1.PodDisruptionBudgetStatus{DisruptionsAllowed: allowed},
+		}
+	}
+
+	daemon := pod("agent", "node-1", map[string]string{"app": "agent"})
+	daemon.OwnerReferences = []metav1.OwnerReference{{Kind: "DaemonSet", Name: "agent", Controller: &[]bool{true}[0]}}
+	done := pod("job", "node-1", map[string]string{"app": "job"})
+	done.Status.Phase = v1.PodSucceeded
+
+	tests := []struct {
+		name    string
+		objects []runtime.Object
+		blocked bool
+		reason  string
+	}{
+		{"no budgets", []runtime.Object{pod("db-0", "node-1", map[string]string{"app": "db"})}, false, ""},
+		{"budget allows", []runtime.Object{pod("db-0", "node-1", map[string]string{"app": "db"}), pdb("db", "db", 1)}, false, ""},
+		{"budget exhausted", []runtime.Object{pod("db-0", "node-1", map[string]string{"app": "db"}), pdb("db", "db", 0)}, true,
+			"pod disruption budgets allowing no disruptions: [default/db (pod default/db-0)]"},
+		{"other pods", []runtime.Object{pod("web", "node-1", map[string]string{"app": "web"}), pdb("db", "db", 0)}, false, ""},
+		{"daemonset pod", []runtime.Object{daemon, pdb("agent", "agent", 0)}, false, ""},
+		{"completed pod", []runtime.Object{done, pdb("job", "job", 0)}, false, ""},
+	}
+
+	for _, tst := range tests {
+		client := fake.NewSimpleClientset(tst.objects...)
+		blocked, reason := NewPodDisruptionBudgetBlocker("pdb", client, "node-1").IsBlocked(context.Background())
+		if blocked != tst.blocked || reason != tst.reason {
+			t.Errorf("Test %s: expected (%v, %q) got (%v, %q)", tst.name, tst.blocked, tst.reason, blocked, reason)
+		}
+	}
+}
+
+func TestClusterHealthBlocker(t *testing.T) {
+	node := func(name string, pool string, ready bool, unschedulable bool, pressure v1.NodeConditionType) *v1.Node {
+		status := v1.ConditionFalse
+		if ready {
+			status = v1.ConditionTrue
+		}
+		n := &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"pool": pool}},
+			Spec:       v1.NodeSpec{Unschedulable: unschedulable},
+			Status:     v1.NodeStatus{Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: status}}},
+		}
+		if pressure != "" {
+			n.Status.Conditions = append(n.Status.Conditions, v1.NodeCondition{Type: pressure, Status: v1.ConditionTrue})
+		}
+		return n
+	}
+	holder := func(name string) Rebooting {
+		return LockHolder(func(context.Context) (string, error) { return name, nil })
+	}
+
+	tests := []struct {
+		name    string
+		nodes   []runtime.Object
+		holder  string
+		options ClusterHealthOptions
+		blocked bool
+		reason  string
+	}{
+		{"healthy", []runtime.Object{node("a", "x", true, false, ""), node("b", "x", true, false, "")}, "",
+			ClusterHealthOptions{MinReadyPercent: 100, BlockOnNotReady: true, BlockOnCordoned: true, BlockOnPressure: true}, false, ""},
+		{"too few ready", []runtime.Object{node("a", "x", true, false, ""), node("b", "x", false, false, ""), node("c", "x", true, false, "")}, "",
+			ClusterHealthOptions{MinReadyPercent: 80}, true, "2 of 3 nodes ready, less than 80%"},
+		{"enough ready", []runtime.Object{node("a", "x", true, false, ""), node("b", "x", false, false, ""), node("c", "x", true, false, "")}, "",
+			ClusterHealthOptions{MinReadyPercent: 60}, false, ""},
+		{"other not ready", []runtime.Object{node("a", "x", true, false, ""), node("b", "x", false, false, "")}, "",
+			ClusterHealthOptions{BlockOnNotReady: true}, true, "nodes not ready: [b]"},
+		{"self not ready", []runtime.Object{node("a", "x", false, false, ""), node("b", "x", true, false, "")}, "",
+			ClusterHealthOptions{BlockOnNotReady: true}, false, ""},
+		{"other cordoned", []runtime.Object{node("a", "x", true, false, ""), node("b", "x", true, true, "")}, "",
+			ClusterHealthOptions{BlockOnCordoned: true}, true, "nodes cordoned: [b]"},
+		{"lock holder cordoned", []runtime.Object{node("a", "x", true, false, ""), node("b", "x", true, true, "")}, "b",
+			ClusterHealthOptions{BlockOnCordoned: true}, false, ""},
+		{"pressure", []runtime.Object{node("a", "x", true, false, ""), node("b", "x", true, false, v1.NodeDiskPressure)}, "",
+			ClusterHealthOptions{BlockOnPressure: true}, true, "nodes under pressure: [b (DiskPressure)]"},
+		{"node selector", []runtime.Object{node("a", "x", true, false, ""), node("b", "y", false, true, "")}, "",
+			ClusterHealthOptions{NodeSelector: "pool=x", BlockOnNotReady: true, BlockOnCordoned: true}, false, ""},
+	}
+
+	for _, tst := range tests {
+		client := fake.NewSimpleClientset(tst.nodes...)
+		blocked, reason := NewClusterHealthBlocker("health", client, "a", holder(tst.holder), tst.options).IsBlocked(context.Background())
+		if blocked != tst.blocked || reason != tst.reason {
+			t.Errorf("Test %s: expected (%v, %q) got (%v, %q)", tst.name, tst.blocked, tst.reason, blocked, reason)
+		}
+	}
+
+	// Several nodes may be rebooting at the same time, e.g. as approved by the controller
+	client := fake.NewSimpleClientset(node("a", "x", true, false, ""), node("b", "x", true, true, ""), node("c", "x", true, true, ""))
+	rebooting := func(context.Context) (func(node *v1.Node) bool, error) {
+		return func(node *v1.Node) bool { return node.Name == "b" }, nil
+	}
+	blocked, reason := NewClusterHealthBlocker("health", client, "a", rebooting, ClusterHealthOptions{BlockOnCordoned: true}).IsBlocked(context.Background())
+	if !blocked || reason != "nodes cordoned: [c]" {
+		t.Errorf("Test rebooting predicate: expected (true, %q) got (%v, %q)", "nodes cordoned: [c]", blocked, reason)
+	}
+}
+
+func TestPauseBlocker(t *testing.T) {
+	const annotation = "kured-paused"
+	annotated := func(value string) map[string]string {
+		if value == "" {
+			return nil
+		}
+		return map[string]string{annotation: value}
+	}
+	objects := func(ds, cm, node string) []runtime.Object {
+		objects := []runtime.Object{
+			&appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: "kured", Namespace: "kube-system", Annotations: annotated(ds)}},
+			&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Annotations: annotated(node)}},
+		}
+		if cm != "-" {
+			objects = append(objects, &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "kured-pause", Namespace: "kube-system", Annotations: annotated(cm)}})
+		}
+		return objects
+	}
+
+	tests := []struct {
+		name    string
+		objects []runtime.Object
+		blocked bool
+		reason  string
+	}{
+		{"not paused", objects("", "", ""), false, ""},
+		{"configmap missing", objects("", "-", ""), false, ""},
+		{"explicitly unpaused", objects("false", "False", "false"), false, ""},
+		{"daemonset", objects("incident 42", "", ""), true, "paused cluster-wide via daemonset kube-system/kured: incident 42"},
+		{"configmap", objects("", "true", ""), true, "paused cluster-wide via configmap kube-system/kured-pause: true"},
+		{"node", objects("", "", "true"), true, "paused for node node-1: true"},
+	}
+
+	for _, tst := range tests {
+		client := fake.NewSimpleClientset(tst.objects...)
+		blocked, reason := NewPauseBlocker("pause", client, "node-1", "kube-system", "kured", "kured-pause", annotation).IsBlocked(context.Background())
+		if blocked != tst.blocked || reason != tst.reason {
+			t.Errorf("Test %s: expected (%v, %q) got (%v, %q)", tst.name, tst.blocked, tst.reason, blocked, reason)
+		}
+	}
+}
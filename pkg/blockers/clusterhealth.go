@@ -0,0 +1,150 @@
+package blockers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ClusterHealthOptions selects the checks performed by a ClusterHealthBlocker.
+type ClusterHealthOptions struct {
+	// NodeSelector is a label selector restricting the nodes considered.
+	NodeSelector string
+	// MinReadyPercent blocks if fewer than this percentage of nodes are Ready.
+	MinReadyPercent int
+	// BlockOnNotReady blocks if any other node is not Ready.
+	BlockOnNotReady bool
+	// BlockOnCordoned blocks if any other node is cordoned, unless kured is rebooting it.
+	BlockOnCordoned bool
+	// BlockOnPressure blocks if any other node reports memory, disk or PID pressure.
+	BlockOnPressure bool
+}
+
+// Rebooting returns a predicate determining whether kured is rebooting a
+// node, whose cordon is then expected. It is called once per check.
+type Rebooting func(ctx context.Context) (func(node *v1.Node) bool, error)
+
+// LockHolder determines the node kured is rebooting from the holder of the
+// reboot lock, as returned by lockHolder.
+func LockHolder(lockHolder func(ctx context.Context) (string, error)) Rebooting {
+	return func(ctx context.Context) (func(node *v1.Node) bool, error) {
+		holder, err := lockHolder(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return func(node *v1.Node) bool {
+			return holder != "" && node.Name == holder
+		}, nil
+	}
+}
+
+// ClusterHealthBlocker blocks reboots while the cluster is already degraded.
+type ClusterHealthBlocker struct {
+	name      string
+	client    kubernetes.Interface
+	nodeID    string
+	rebooting Rebooting
+	options   ClusterHealthOptions
+}
+
+// NewClusterHealthBlocker creates a blocker checking the health of the nodes
+// other than nodeID. rebooting tells the nodes kured is rebooting, whose
+// cordon is expected.
+func NewClusterHealthBlocker(name string, client kubernetes.Interface, nodeID string, rebooting Rebooting, options ClusterHealthOptions) *ClusterHealthBlocker {
+	return &ClusterHealthBlocker{name: name, client: client, nodeID: nodeID, rebooting: rebooting, options: options}
+}
+
+// Name implements Blocker.
+func (cb *ClusterHealthBlocker) Name() string {
+	return cb.name
+}
+
+// IsBlocked implements Blocker.
+func (cb *ClusterHealthBlocker) IsBlocked(ctx context.Context) (bool, string) {
+	nodeList, err := cb.client.CoreV1().Nodes().List(ctx, metav1.ListOptions{LabelSelector: cb.options.NodeSelector})
+	if err != nil {
+		return true, fmt.Sprintf("node query error: %v", err)
+	}
+
+	rebooting := func(node *v1.Node) bool { return false }
+	if cb.options.BlockOnCordoned {
+		if rebooting, err = cb.rebooting(ctx); err != nil {
+			return true, fmt.Sprintf("lock query error: %v", err)
+		}
+	}
+
+	var reasons []string
+	var notReady, cordoned, pressure []string
+	ready := 0
+	for i := range nodeList.Items {
+		node := nodeList.Items[i]
+		isReady := nodeCondition(node, v1.NodeReady)
+		if isReady {
+			ready++
+		}
+
+		if node.Name == cb.nodeID {
+			continue
+		}
+
+		if cb.options.BlockOnNotReady && !isReady {
+			notReady = append(notReady, node.Name)
+		}
+
+		if cb.options.BlockOnCordoned && node.Spec.Unschedulable && !rebooting(&node) {
+			cordoned = append(cordoned, node.Name)
+		}
+
+		if cb.options.BlockOnPressure {
+			for _, condition := range []v1.NodeConditionType{v1.NodeMemoryPressure, v1.NodeDiskPressure, v1.NodePIDPressure} {
+				if nodeCondition(node, condition) {
+					pressure = append(pressure, fmt.Sprintf("%s (%s)", node.Name, condition))
+				}
+			}
+		}
+	}
+
+	if cb.options.MinReadyPercent > 0 {
+		total := len(nodeList.Items)
+		if total == 0 || ready*100 < cb.options.MinReadyPercent*total {
+			reasons = append(reasons, fmt.Sprintf("%d of %d nodes ready, less than %d%%", ready, total, cb.options.MinReadyPercent))
+		}
+	}
+	if len(notReady) > 0 {
+		reasons = append(reasons, fmt.Sprintf("nodes not ready: %v", truncate(notReady)))
+	}
+	if len(cordoned) > 0 {
+		reasons = append(reasons, fmt.Sprintf("nodes cordoned: %v", truncate(cordoned)))
+	}
+	if len(pressure) > 0 {
+		reasons = append(reasons, fmt.Sprintf("nodes under pressure: %v", truncate(pressure)))
+	}
+
+	if len(reasons) > 0 {
+		return true, strings.Join(reasons, "; ")
+	}
+
+	return false, ""
+}
+
+// nodeCondition returns true if the node reports the condition as true.
+func nodeCondition(node v1.Node, conditionType v1.NodeConditionType) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == conditionType {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// truncate limits a list of names to ten entries for reporting.
+func truncate(names []string) []string {
+	if len(names) > 10 {
+		return append(names[:10:10], "...")
+	}
+	return names
+}
@@ -0,0 +1,79 @@
+package blockers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// PauseBlocker blocks reboots while an operator has paused them by
+// annotating the kured daemonset, an optional ConfigMap or the node itself.
+// Any annotation value other than "false" pauses reboots and is reported as
+// the reason.
+type PauseBlocker struct {
+	name       string
+	client     kubernetes.Interface
+	nodeID     string
+	namespace  string
+	dsName     string
+	configMap  string
+	annotation string
+}
+
+// NewPauseBlocker creates a blocker looking for annotation on the daemonset
+// dsName and, if configMap is not empty, the ConfigMap of that name, both in
+// namespace, as well as on the node nodeID.
+func NewPauseBlocker(name string, client kubernetes.Interface, nodeID, namespace, dsName, configMap, annotation string) *PauseBlocker {
+	return &PauseBlocker{name: name, client: client, nodeID: nodeID, namespace: namespace, dsName: dsName, configMap: configMap, annotation: annotation}
+}
+
+// Name implements Blocker.
+func (pb *PauseBlocker) Name() string {
+	return pb.name
+}
+
+// IsBlocked implements Blocker.
+func (pb *PauseBlocker) IsBlocked(ctx context.Context) (bool, string) {
+	ds, err := pb.client.AppsV1().DaemonSets(pb.namespace).Get(ctx, pb.dsName, metav1.GetOptions{})
+	if err != nil {
+		return true, fmt.Sprintf("daemonset query error: %v", err)
+	}
+	if paused, reason := pb.paused(ds.Annotations); paused {
+		return true, fmt.Sprintf("paused cluster-wide via daemonset %s/%s: %s", pb.namespace, pb.dsName, reason)
+	}
+
+	if pb.configMap != "" {
+		cm, err := pb.client.CoreV1().ConfigMaps(pb.namespace).Get(ctx, pb.configMap, metav1.GetOptions{})
+		switch {
+		case errors.IsNotFound(err):
+		case err != nil:
+			return true, fmt.Sprintf("configmap query error: %v", err)
+		default:
+			if paused, reason := pb.paused(cm.Annotations); paused {
+				return true, fmt.Sprintf("paused cluster-wide via configmap %s/%s: %s", pb.namespace, pb.configMap, reason)
+			}
+		}
+	}
+
+	node, err := pb.client.CoreV1().Nodes().Get(ctx, pb.nodeID, metav1.GetOptions{})
+	if err != nil {
+		return true, fmt.Sprintf("node query error: %v", err)
+	}
+	if paused, reason := pb.paused(node.Annotations); paused {
+		return true, fmt.Sprintf("paused for node %s: %s", pb.nodeID, reason)
+	}
+
+	return false, ""
+}
+
+func (pb *PauseBlocker) paused(annotations map[string]string) (bool, string) {
+	value, exists := annotations[pb.annotation]
+	if !exists || strings.ToLower(value) == "false" {
+		return false, ""
+	}
+	return true, value
+}
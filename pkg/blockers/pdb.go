@@ -0,0 +1,96 @@
+package blockers
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// PodDisruptionBudgetBlocker blocks reboots while any pod disruption budget
+// covering pods on the node allows no further disruptions, since draining
+// the node would then fail anyway.
+type PodDisruptionBudgetBlocker struct {
+	name   string
+	client kubernetes.Interface
+	nodeID string
+}
+
+// NewPodDisruptionBudgetBlocker creates a blocker checking the pod disruption
+// budgets of pods on nodeID.
+func NewPodDisruptionBudgetBlocker(name string, client kubernetes.Interface, nodeID string) *PodDisruptionBudgetBlocker {
+	return &PodDisruptionBudgetBlocker{name: name, client: client, nodeID: nodeID}
+}
+
+// Name implements Blocker.
+func (pb *PodDisruptionBudgetBlocker) Name() string {
+	return pb.name
+}
+
+// IsBlocked implements Blocker.
+func (pb *PodDisruptionBudgetBlocker) IsBlocked(ctx context.Context) (bool, string) {
+	podList, err := pb.client.CoreV1().Pods("").List(ctx, metav1.ListOptions{
+		FieldSelector: fmt.Sprintf("spec.nodeName=%s", pb.nodeID)})
+	if err != nil {
+		return true, fmt.Sprintf("pod query error: %v", err)
+	}
+
+	budgets := make(map[string][]policyv1beta1.PodDisruptionBudget)
+	violated := make(map[string]bool)
+	var violations []string
+	for _, pod := range podList.Items {
+		if !evictable(pod) {
+			continue
+		}
+
+		namespaceBudgets, ok := budgets[pod.Namespace]
+		if !ok {
+			pdbList, err := pb.client.PolicyV1beta1().PodDisruptionBudgets(pod.Namespace).List(ctx, metav1.ListOptions{})
+			if err != nil {
+				return true, fmt.Sprintf("pod disruption budget query error: %v", err)
+			}
+			namespaceBudgets = pdbList.Items
+			budgets[pod.Namespace] = namespaceBudgets
+		}
+
+		for _, pdb := range namespaceBudgets {
+			name := pdb.Namespace + "/" + pdb.Name
+			if violated[name] || pdb.Status.DisruptionsAllowed > 0 || pdb.Spec.Selector == nil {
+				continue
+			}
+
+			selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+			if err != nil || selector.Empty() || !selector.Matches(labels.Set(pod.Labels)) {
+				continue
+			}
+
+			violated[name] = true
+			violations = append(violations, fmt.Sprintf("%s (pod %s/%s)", name, pod.Namespace, pod.Name))
+		}
+	}
+
+	if len(violations) > 0 {
+		return true, fmt.Sprintf("pod disruption budgets allowing no disruptions: %v", violations)
+	}
+
+	return false, ""
+}
+
+// evictable returns true for pods which a drain would evict, i.e. excluding
+// those managed by a DaemonSet, mirror pods and terminated pods.
+func evictable(pod v1.Pod) bool {
+	if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+		return false
+	}
+	if _, mirror := pod.Annotations[v1.MirrorPodAnnotationKey]; mirror {
+		return false
+	}
+	if controller := metav1.GetControllerOf(&pod); controller != nil && controller.Kind == "DaemonSet" {
+		return false
+	}
+	return true
+}
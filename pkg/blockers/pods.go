@@ -0,0 +1,168 @@
+package blockers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// PodSelector identifies pods whose presence on a node blocks its reboot.
+// Empty fields match every pod.
+type PodSelector struct {
+	// Namespaces restricts the selector to pods in these namespaces.
+	Namespaces []string
+	// LabelSelector matches pod labels.
+	LabelSelector string
+	// AnnotationSelector matches pod annotations, given as a comma separated
+	// list of key=value pairs and of keys which must be present.
+	AnnotationSelector string
+	// OwnerKinds matches pods owned by a resource of one of these kinds, e.g. Job.
+	OwnerKinds []string
+	// IgnoreCompleted disregards pods which have succeeded or failed.
+	IgnoreCompleted bool
+}
+
+// String returns a description of the selector for logging.
+func (ps PodSelector) String() string {
+	var parts []string
+	if ps.LabelSelector != "" {
+		parts = append(parts, ps.LabelSelector)
+	}
+	if ps.AnnotationSelector != "" {
+		parts = append(parts, fmt.Sprintf("annotations:%s", ps.AnnotationSelector))
+	}
+	if len(ps.OwnerKinds) > 0 {
+		parts = append(parts, fmt.Sprintf("owners:%v", ps.OwnerKinds))
+	}
+	if len(ps.Namespaces) > 0 {
+		parts = append(parts, fmt.Sprintf("namespaces:%v", ps.Namespaces))
+	}
+	if ps.IgnoreCompleted {
+		parts = append(parts, "running")
+	}
+	if len(parts) == 0 {
+		return "any"
+	}
+	return strings.Join(parts, " ")
+}
+
+// PodBlocker blocks reboots while pods matching any of its selectors are
+// scheduled on the node.
+type PodBlocker struct {
+	name      string
+	client    kubernetes.Interface
+	nodeID    string
+	selectors []PodSelector
+}
+
+// NewPodBlocker creates a blocker looking for pods on nodeID matching any of
+// the selectors.
+func NewPodBlocker(name string, client kubernetes.Interface, nodeID string, selectors []PodSelector) *PodBlocker {
+	return &PodBlocker{name: name, client: client, nodeID: nodeID, selectors: selectors}
+}
+
+// Name implements Blocker.
+func (pb *PodBlocker) Name() string {
+	return pb.name
+}
+
+// IsBlocked implements Blocker.
+func (pb *PodBlocker) IsBlocked(ctx context.Context) (bool, string) {
+	fieldSelector := fmt.Sprintf("spec.nodeName=%s", pb.nodeID)
+	for _, selector := range pb.selectors {
+		annotations, err := parseAnnotationSelector(selector.AnnotationSelector)
+		if err != nil {
+			return true, fmt.Sprintf("invalid annotation selector %q: %v", selector.AnnotationSelector, err)
+		}
+
+		namespaces := selector.Namespaces
+		if len(namespaces) == 0 {
+			namespaces = []string{metav1.NamespaceAll}
+		}
+
+		var podNames []string
+		for _, namespace := range namespaces {
+			podList, err := pb.client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+				LabelSelector: selector.LabelSelector,
+				FieldSelector: fieldSelector})
+			if err != nil {
+				return true, fmt.Sprintf("pod query error: %v", err)
+			}
+
+			for _, pod := range podList.Items {
+				if selector.IgnoreCompleted && (pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed) {
+					continue
+				}
+				if !annotations.matches(pod.Annotations) {
+					continue
+				}
+				if len(selector.OwnerKinds) > 0 && !ownedBy(pod, selector.OwnerKinds) {
+					continue
+				}
+				podNames = append(podNames, pod.Namespace+"/"+pod.Name)
+			}
+		}
+
+		if len(podNames) > 0 {
+			return true, fmt.Sprintf("%d pods matching %s: %v", len(podNames), selector, truncate(podNames))
+		}
+	}
+
+	return false, ""
+}
+
+// annotationSelector maps annotation keys to the values they must have, or
+// to nil if they merely must be present. Unlike label values, annotation
+// values are free form, so label selector syntax does not apply to them.
+type annotationSelector map[string]*string
+
+// parseAnnotationSelector parses a comma separated list of key=value pairs
+// and keys. Values may contain anything but commas.
+func parseAnnotationSelector(s string) (annotationSelector, error) {
+	selector := annotationSelector{}
+	if strings.TrimSpace(s) == "" {
+		return selector, nil
+	}
+	for _, term := range strings.Split(s, ",") {
+		key := term
+		var value *string
+		if i := strings.Index(term, "="); i >= 0 {
+			v := strings.TrimSpace(term[i+1:])
+			key, value = term[:i], &v
+		}
+		key = strings.TrimSpace(key)
+		if key == "" {
+			return nil, fmt.Errorf("Missing annotation key in %q", term)
+		}
+		selector[key] = value
+	}
+	return selector, nil
+}
+
+// matches returns true if the annotations have all keys of the selector,
+// with the values required.
+func (as annotationSelector) matches(annotations map[string]string) bool {
+	for key, value := range as {
+		actual, ok := annotations[key]
+		if !ok || (value != nil && actual != *value) {
+			return false
+		}
+	}
+	return true
+}
+
+// ownedBy returns true if the pod has an owner of any of the kinds.
+func ownedBy(pod v1.Pod, kinds []string) bool {
+	for _, ref := range pod.OwnerReferences {
+		for _, kind := range kinds {
+			if ref.Kind == kind {
+				return true
+			}
+		}
+	}
+	return false
+}
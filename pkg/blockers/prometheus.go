@@ -0,0 +1,49 @@
+package blockers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/weaveworks/kured/pkg/alerts"
+)
+
+// PrometheusBlocker blocks reboots while Prometheus reports active alerts.
+type PrometheusBlocker struct {
+	name          string
+	prometheusURL string
+	filter        *alerts.Filter
+}
+
+// NewPrometheusBlocker creates a blocker querying the Prometheus instance at
+// prometheusURL for active alerts passing filter.
+func NewPrometheusBlocker(name, prometheusURL string, filter *alerts.Filter) *PrometheusBlocker {
+	return &PrometheusBlocker{name: name, prometheusURL: prometheusURL, filter: filter}
+}
+
+// Name implements Blocker.
+func (pb *PrometheusBlocker) Name() string {
+	return pb.name
+}
+
+// IsBlocked implements Blocker.
+func (pb *PrometheusBlocker) IsBlocked(ctx context.Context) (bool, string) {
+	alertNames, err := alerts.PrometheusActiveAlerts(ctx, pb.prometheusURL, pb.filter)
+	if err != nil {
+		return true, fmt.Sprintf("prometheus query error: %v", err)
+	}
+
+	return activeAlertsBlocked(alertNames)
+}
+
+// activeAlertsBlocked blocks if there are any active alerts.
+func activeAlertsBlocked(alertNames []string) (bool, string) {
+	count := len(alertNames)
+	if count > 10 {
+		alertNames = append(alertNames[:10], "...")
+	}
+	if count > 0 {
+		return true, fmt.Sprintf("%d active alerts: %v", count, alertNames)
+	}
+
+	return false, ""
+}
@@ -0,0 +1,57 @@
+package blockers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/weaveworks/kured/pkg/alerts"
+)
+
+// QueryBlocker blocks reboots depending on the result of a PromQL expression.
+type QueryBlocker struct {
+	name          string
+	prometheusURL string
+	query         string
+	threshold     *float64
+}
+
+// NewQueryBlocker creates a blocker evaluating query against the Prometheus
+// instance at prometheusURL. Without a threshold any non-empty result blocks
+// reboots; otherwise only samples with a value above the threshold do.
+func NewQueryBlocker(name, prometheusURL, query string, threshold *float64) *QueryBlocker {
+	return &QueryBlocker{name: name, prometheusURL: prometheusURL, query: query, threshold: threshold}
+}
+
+// Name implements Blocker.
+func (qb *QueryBlocker) Name() string {
+	return qb.name
+}
+
+// IsBlocked implements Blocker.
+func (qb *QueryBlocker) IsBlocked(ctx context.Context) (bool, string) {
+	vector, err := alerts.PrometheusQuery(ctx, qb.prometheusURL, qb.query)
+	if err != nil {
+		return true, fmt.Sprintf("prometheus query error: %v", err)
+	}
+
+	var matches []string
+	for _, sample := range vector {
+		if qb.threshold == nil || float64(sample.Value) > *qb.threshold {
+			matches = append(matches, fmt.Sprintf("%v => %v", sample.Metric, sample.Value))
+		}
+	}
+
+	count := len(matches)
+	if count > 10 {
+		matches = append(matches[:10], "...")
+	}
+	if count == 0 {
+		return false, ""
+	}
+	if qb.threshold == nil {
+		return true, fmt.Sprintf("%d matching series: %v", count, matches)
+	}
+	return true, fmt.Sprintf("%d series above %v: %v", count, model.SampleValue(*qb.threshold), matches)
+}
@@ -0,0 +1,37 @@
+package blockers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/weaveworks/kured/pkg/ratelimit"
+)
+
+// RateLimitBlocker blocks reboots while the cluster-wide rate limits are
+// reached or the circuit breaker is tripped.
+type RateLimitBlocker struct {
+	name   string
+	store  *ratelimit.Store
+	limits ratelimit.Limits
+}
+
+// NewRateLimitBlocker creates a blocker checking the reboot history kept in
+// store against limits.
+func NewRateLimitBlocker(name string, store *ratelimit.Store, limits ratelimit.Limits) *RateLimitBlocker {
+	return &RateLimitBlocker{name: name, store: store, limits: limits}
+}
+
+// Name implements Blocker.
+func (rb *RateLimitBlocker) Name() string {
+	return rb.name
+}
+
+// IsBlocked implements Blocker.
+func (rb *RateLimitBlocker) IsBlocked(ctx context.Context) (bool, string) {
+	history, err := rb.store.Load(ctx)
+	if err != nil {
+		return true, fmt.Sprintf("reboot history query error: %v", err)
+	}
+	return history.Check(rb.limits, time.Now())
+}
@@ -0,0 +1,41 @@
+package blockers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/weaveworks/kured/pkg/rollout"
+)
+
+// RolloutBlocker blocks the reboot of a node until the earlier waves of a
+// rollout plan have rebooted and soaked, and while the rollout is halted.
+type RolloutBlocker struct {
+	name   string
+	client kubernetes.Interface
+	nodeID string
+	plan   *rollout.Plan
+}
+
+// NewRolloutBlocker creates a blocker holding back the reboot of nodeID
+// according to plan.
+func NewRolloutBlocker(name string, client kubernetes.Interface, nodeID string, plan *rollout.Plan) *RolloutBlocker {
+	return &RolloutBlocker{name: name, client: client, nodeID: nodeID, plan: plan}
+}
+
+// Name implements Blocker.
+func (rb *RolloutBlocker) Name() string {
+	return rb.name
+}
+
+// IsBlocked implements Blocker.
+func (rb *RolloutBlocker) IsBlocked(ctx context.Context) (bool, string) {
+	nodeList, err := rb.client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return true, fmt.Sprintf("node query error: %v", err)
+	}
+	return rb.plan.Check(rb.nodeID, nodeList.Items, time.Now())
+}
@@ -0,0 +1,143 @@
+package blockers
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// WebhookOptions configures a WebhookBlocker.
+type WebhookOptions struct {
+	// URL is the endpoint receiving the reboot request.
+	URL string
+	// Timeout bounds each request.
+	Timeout time.Duration
+	// FailOpen allows reboots if the endpoint cannot be reached or answers
+	// with an error; by default such failures block reboots.
+	FailOpen bool
+	// ClusterName and Reasons are passed on to the endpoint.
+	ClusterName string
+	Reasons     []string
+	// CAFile verifies the endpoint's certificate instead of the system roots.
+	CAFile string
+	// CertFile and KeyFile hold a client certificate authenticating kured.
+	CertFile string
+	KeyFile  string
+}
+
+// webhookRequest is the body posted to the webhook endpoint.
+type webhookRequest struct {
+	Node    string   `json:"node"`
+	Cluster string   `json:"cluster,omitempty"`
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+// webhookResponse is the body expected from the webhook endpoint.
+type webhookResponse struct {
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// WebhookBlocker asks an external system, such as a change freeze calendar,
+// whether the node may be rebooted now.
+type WebhookBlocker struct {
+	name    string
+	nodeID  string
+	options WebhookOptions
+	client  *http.Client
+}
+
+// NewWebhookBlocker creates a blocker posting the reboot request for nodeID
+// to the endpoint described by options.
+func NewWebhookBlocker(name, nodeID string, options WebhookOptions) (*WebhookBlocker, error) {
+	tlsConfig := &tls.Config{}
+
+	if options.CAFile != "" {
+		ca, err := ioutil.ReadFile(options.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("No certificates found in %s", options.CAFile)
+		}
+	}
+
+	if options.CertFile != "" || options.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(options.CertFile, options.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	client := &http.Client{
+		Timeout:   options.Timeout,
+		Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig},
+	}
+
+	return &WebhookBlocker{name: name, nodeID: nodeID, options: options, client: client}, nil
+}
+
+// Name implements Blocker.
+func (wb *WebhookBlocker) Name() string {
+	return wb.name
+}
+
+// IsBlocked implements Blocker.
+func (wb *WebhookBlocker) IsBlocked(ctx context.Context) (bool, string) {
+	response, err := wb.call(ctx)
+	if err != nil {
+		if wb.options.FailOpen {
+			return false, fmt.Sprintf("ignoring webhook error: %v", err)
+		}
+		return true, fmt.Sprintf("webhook error: %v", err)
+	}
+
+	if !response.Allowed {
+		if response.Reason == "" {
+			return true, "denied by webhook"
+		}
+		return true, fmt.Sprintf("denied by webhook: %s", response.Reason)
+	}
+
+	return false, ""
+}
+
+func (wb *WebhookBlocker) call(ctx context.Context) (*webhookResponse, error) {
+	request := webhookRequest{Node: wb.nodeID, Cluster: wb.options.ClusterName, Reasons: wb.options.Reasons}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(&request); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wb.options.URL, &buf)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := wb.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf(resp.Status)
+	}
+
+	response := &webhookResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+		return nil, fmt.Errorf("Invalid response: %v", err)
+	}
+
+	return response, nil
+}
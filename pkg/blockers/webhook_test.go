@@ -0,0 +1,168 @@
+package blockers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWebhookBlocker(t *testing.T) {
+	var received webhookRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		switch received.Node {
+		case "allowed":
+			fmt.Fprint(w, `{"allowed": true}`)
+		case "denied":
+			fmt.Fprint(w, `{"allowed": false, "reason": "change freeze"}`)
+		case "slow":
+			time.Sleep(500 * time.Millisecond)
+			fmt.Fprint(w, `{"allowed": true}`)
+		case "garbage":
+			fmt.Fprint(w, `not json`)
+		default:
+			http.Error(w, "unknown node", http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		node     string
+		failOpen bool
+		blocked  bool
+	}{
+		{"allowed", false, false},
+		{"denied", false, true},
+		{"denied", true, true},
+		{"unknown", false, true},
+		{"unknown", true, false},
+		{"garbage", false, true},
+		{"slow", false, true},
+		{"slow", true, false},
+	}
+
+	for _, tst := range tests {
+		blocker, err := NewWebhookBlocker("webhook", tst.node, WebhookOptions{
+			URL:         server.URL,
+			Timeout:     100 * time.Millisecond,
+			FailOpen:    tst.failOpen,
+			ClusterName: "prod",
+			Reasons:     []string{"sentinel"},
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		blocked, reason := blocker.IsBlocked(context.Background())
+		if blocked != tst.blocked {
+			t.Errorf("Test %s (fail open %v): expected %v got %v (%s)", tst.node, tst.failOpen, tst.blocked, blocked, reason)
+		}
+	}
+
+	expected := webhookRequest{Node: "slow", Cluster: "prod", Reasons: []string{"sentinel"}}
+	if !reflect.DeepEqual(received, expected) {
+		t.Errorf("Expected request %v got %v", expected, received)
+	}
+}
+
+func TestWebhookBlockerTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webhook")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	clientCert := writeCertificate(t, certFile, keyFile)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 || !r.TLS.PeerCertificates[0].Equal(clientCert) {
+			http.Error(w, "unknown client", http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, `{"allowed": true}`)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	caFile := filepath.Join(dir, "ca.crt")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, ca, 0600); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	blocker, err := NewWebhookBlocker("webhook", "node-1", WebhookOptions{
+		URL: server.URL, Timeout: time.Second, CAFile: caFile, CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if blocked, reason := blocker.IsBlocked(context.Background()); blocked {
+		t.Errorf("Expected authenticated request to be allowed: %s", reason)
+	}
+
+	blocker, err = NewWebhookBlocker("webhook", "node-1", WebhookOptions{
+		URL: server.URL, Timeout: time.Second, CAFile: caFile})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if blocked, _ := blocker.IsBlocked(context.Background()); !blocked {
+		t.Errorf("Expected unauthenticated request to be blocked")
+	}
+
+	if _, err := NewWebhookBlocker("webhook", "node-1", WebhookOptions{URL: server.URL, CAFile: keyFile}); err == nil {
+		t.Errorf("Expected error for CA file without certificates")
+	}
+}
+
+// writeCertificate writes a self-signed client certificate and its key.
+func writeCertificate(t *testing.T, certFile, keyFile string) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "kured"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return cert
+}
@@ -0,0 +1,160 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Node annotations through which the controller and the node agents
+// communicate, and which record the reboot state of nodes.
+const (
+	// RebootRequiredAnnotation is set by the agent, to the time it first
+	// noticed, while the node requires a reboot.
+	RebootRequiredAnnotation = "weave.works/kured-reboot-required"
+	// RebootApprovedAnnotation is set by the controller, to the time of the
+	// approval, to tell the agent to drain and reboot the node.
+	RebootApprovedAnnotation = "weave.works/kured-reboot-approved"
+	// RebootInProgressAnnotation is set by the agent once it starts draining
+	// the node, to information it needs after the reboot.
+	RebootInProgressAnnotation = "weave.works/kured-reboot-in-progress"
+	// LastRebootAnnotation is set by kured, to the time the reboot
+	// completed, once the node is back.
+	LastRebootAnnotation = "weave.works/kured-last-reboot"
+	// RebootFailedAnnotation is set by kured, to the reason, when the node
+	// is unhealthy after its reboot. Reboot rollouts halt until it is
+	// removed.
+	RebootFailedAnnotation = "weave.works/kured-reboot-failed"
+)
+
+// Eligibility determines which nodes may be rebooted during a single
+// reconciliation.
+type Eligibility interface {
+	// Eligible determines whether a node may be rebooted now, e.g. because
+	// its reboot window is open and nothing blocks it, and returns the
+	// reason if not.
+	Eligible(node *v1.Node) (bool, string)
+	// Approved records that the reboot of a node was approved, for it to
+	// count against the limits of the nodes considered next.
+	Approved(node *v1.Node)
+	// Expired determines whether the approval of a node expired and was
+	// withdrawn when the reconciliation started.
+	Expired(node *v1.Node) bool
+}
+
+// Controller approves the reboots of nodes requiring them, keeping at most
+// a given number of reboots in progress.
+type Controller struct {
+	client      kubernetes.Interface
+	strategy    Strategy
+	concurrency int
+	eligibility func(ctx context.Context, nodes []v1.Node) Eligibility
+}
+
+// Status summarizes the nodes seen during a reconciliation.
+type Status struct {
+	// Pending are the nodes requiring a reboot which has not been approved.
+	Pending []string
+	// InProgress are the nodes whose reboot has been approved.
+	InProgress []string
+	// Approved are the nodes approved during the reconciliation.
+	Approved []string
+}
+
+// New creates a controller. eligibility is called at the start of every
+// reconciliation with all the nodes, and determines which of them may be
+// rebooted.
+func New(client kubernetes.Interface, strategy Strategy, concurrency int, eligibility func(ctx context.Context, nodes []v1.Node) Eligibility) *Controller {
+	return &Controller{client: client, strategy: strategy, concurrency: concurrency, eligibility: eligibility}
+}
+
+// Reconcile approves the reboots of as many nodes as the concurrency allows,
+// in the order given by the strategy, and withdraws stale approvals.
+func (c *Controller) Reconcile(ctx context.Context) (*Status, error) {
+	nodeList, err := c.client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	// Expired approvals are withdrawn first, freeing their slots right away
+	eligibility := c.eligibility(ctx, nodeList.Items)
+	status := &Status{}
+	var candidates []Candidate
+	for i := range nodeList.Items {
+		node := &nodeList.Items[i]
+		since, required := node.Annotations[RebootRequiredAnnotation]
+		_, approved := node.Annotations[RebootApprovedAnnotation]
+		_, inProgress := node.Annotations[RebootInProgressAnnotation]
+
+		switch {
+		case approved && !required && !inProgress:
+			log.Infof("Withdrawing reboot approval of node %s, which no longer requires a reboot", node.Name)
+			if err := PatchNodeAnnotations(ctx, c.client, node.Name, map[string]*string{RebootApprovedAnnotation: nil}); err != nil {
+				log.Warnf("Error withdrawing reboot approval of node %s: %v", node.Name, err)
+			}
+		case approved && eligibility.Expired(node):
+			// Not approved again before the next reconciliation
+			status.Pending = append(status.Pending, node.Name)
+		case approved:
+			status.InProgress = append(status.InProgress, node.Name)
+		case required:
+			t, err := time.Parse(time.RFC3339, since)
+			if err != nil {
+				log.Warnf("Invalid %s annotation on node %s: %v", RebootRequiredAnnotation, node.Name, err)
+			}
+			candidates = append(candidates, Candidate{Node: node, Since: t})
+		}
+	}
+
+	slots := c.concurrency - len(status.InProgress)
+	for _, candidate := range c.strategy.Order(candidates) {
+		name := candidate.Node.Name
+		if slots <= 0 {
+			status.Pending = append(status.Pending, name)
+			continue
+		}
+
+		if ok, reason := eligibility.Eligible(candidate.Node); !ok {
+			log.Infof("Not rebooting node %s: %s", name, reason)
+			status.Pending = append(status.Pending, name)
+			continue
+		}
+
+		now := time.Now().UTC().Format(time.RFC3339)
+		if err := PatchNodeAnnotations(ctx, c.client, name, map[string]*string{RebootApprovedAnnotation: &now}); err != nil {
+			log.Warnf("Error approving reboot of node %s: %v", name, err)
+			status.Pending = append(status.Pending, name)
+			continue
+		}
+		log.Infof("Approved reboot of node %s", name)
+		if candidate.Node.Annotations == nil {
+			candidate.Node.Annotations = map[string]string{}
+		}
+		candidate.Node.Annotations[RebootApprovedAnnotation] = now
+		eligibility.Approved(candidate.Node)
+		status.Approved = append(status.Approved, name)
+		status.InProgress = append(status.InProgress, name)
+		slots--
+	}
+
+	return status, nil
+}
+
+// PatchNodeAnnotations sets the annotations of a node, removing those whose
+// value is nil.
+func PatchNodeAnnotations(ctx context.Context, client kubernetes.Interface, name string, annotations map[string]*string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": annotations},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = client.CoreV1().Nodes().Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
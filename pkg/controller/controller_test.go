@@ -0,0 +1,230 @@
+package controller
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testNode(name string, annotations map[string]string) *v1.Node {
+	return &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: annotations}}
+}
+
+func approvedNodes(t *testing.T, client *fake.Clientset) []string {
+	nodeList, err := client.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Failed to list nodes: %v", err)
+	}
+	var names []string
+	for _, node := range nodeList.Items {
+		if _, ok := node.Annotations[RebootApprovedAnnotation]; ok {
+			names = append(names, node.Name)
+		}
+	}
+	return names
+}
+
+// testEligibility blocks the nodes labelled blocked, approves at most
+// perPass nodes per reconciliation if set, and expires the approvals of
+// nodes labelled expired.
+type testEligibility struct {
+	client   *fake.Clientset
+	perPass  int
+	approved int
+}
+
+func (e *testEligibility) Eligible(node *v1.Node) (bool, string) {
+	if node.Labels["blocked"] == "true" {
+		return false, "blocked"
+	}
+	if e.perPass > 0 && e.approved >= e.perPass {
+		return false, "limit reached"
+	}
+	return true, ""
+}
+
+func (e *testEligibility) Approved(node *v1.Node) {
+	e.approved++
+}
+
+func (e *testEligibility) Expired(node *v1.Node) bool {
+	if node.Labels["expired"] != "true" {
+		return false
+	}
+	// Checked through the approved nodes
+	_ = PatchNodeAnnotations(context.Background(), e.client, node.Name, map[string]*string{RebootApprovedAnnotation: nil})
+	return true
+}
+
+func TestReconcile(t *testing.T) {
+
+	blocked := testNode("node-b", map[string]string{RebootRequiredAnnotation: "2019-04-01T00:00:00Z"})
+	blocked.Labels = map[string]string{"blocked": "true"}
+	expired := testNode("node-c", map[string]string{
+		RebootRequiredAnnotation: "2019-04-02T00:00:00Z",
+		RebootApprovedAnnotation: "2019-04-02T01:00:00Z",
+	})
+	expired.Labels = map[string]string{"expired": "true"}
+
+	tests := []struct {
+		name        string
+		concurrency int
+		perPass     int
+		nodes       []runtime.Object
+		approved    []string
+		status      Status
+	}{
+		{
+			name:        "oldest first",
+			concurrency: 1,
+			nodes: []runtime.Object{
+				testNode("node-a", map[string]string{RebootRequiredAnnotation: "2019-04-03T00:00:00Z"}),
+				testNode("node-c", map[string]string{RebootRequiredAnnotation: "2019-04-02T00:00:00Z"}),
+				testNode("node-d", nil),
+			},
+			approved: []string{"node-c"},
+			status:   Status{Pending: []string{"node-a"}, InProgress: []string{"node-c"}, Approved: []string{"node-c"}},
+		},
+		{
+			name:        "skip blocked",
+			concurrency: 1,
+			nodes: []runtime.Object{
+				testNode("node-a", map[string]string{RebootRequiredAnnotation: "2019-04-03T00:00:00Z"}),
+				blocked,
+			},
+			approved: []string{"node-a"},
+			status:   Status{Pending: []string{"node-b"}, InProgress: []string{"node-a"}, Approved: []string{"node-a"}},
+		},
+		{
+			name:        "concurrency exhausted",
+			concurrency: 1,
+			nodes: []runtime.Object{
+				testNode("node-a", map[string]string{RebootRequiredAnnotation: "2019-04-03T00:00:00Z"}),
+				testNode("node-c", map[string]string{
+					RebootRequiredAnnotation: "2019-04-02T00:00:00Z",
+					RebootApprovedAnnotation: "2019-04-02T01:00:00Z",
+				}),
+			},
+			approved: []string{"node-c"},
+			status:   Status{Pending: []string{"node-a"}, InProgress: []string{"node-c"}},
+		},
+		{
+			name:        "concurrency two",
+			concurrency: 2,
+			nodes: []runtime.Object{
+				testNode("node-a", map[string]string{RebootRequiredAnnotation: "2019-04-03T00:00:00Z"}),
+				testNode("node-c", map[string]string{RebootRequiredAnnotation: "2019-04-02T00:00:00Z"}),
+				testNode("node-e", map[string]string{RebootRequiredAnnotation: "2019-04-01T00:00:00Z"}),
+			},
+			approved: []string{"node-c", "node-e"},
+			status:   Status{Pending: []string{"node-a"}, InProgress: []string{"node-e", "node-c"}, Approved: []string{"node-e", "node-c"}},
+		},
+		{
+			name:        "expired approval frees its slot",
+			concurrency: 1,
+			nodes: []runtime.Object{
+				testNode("node-a", map[string]string{RebootRequiredAnnotation: "2019-04-03T00:00:00Z"}),
+				expired,
+			},
+			approved: []string{"node-a"},
+			status:   Status{Pending: []string{"node-c"}, InProgress: []string{"node-a"}, Approved: []string{"node-a"}},
+		},
+		{
+			name:        "limit counted within the reconciliation",
+			concurrency: 2,
+			perPass:     1,
+			nodes: []runtime.Object{
+				testNode("node-a", map[string]string{RebootRequiredAnnotation: "2019-04-03T00:00:00Z"}),
+				testNode("node-c", map[string]string{RebootRequiredAnnotation: "2019-04-02T00:00:00Z"}),
+			},
+			approved: []string{"node-c"},
+			status:   Status{Pending: []string{"node-a"}, InProgress: []string{"node-c"}, Approved: []string{"node-c"}},
+		},
+		{
+			name:        "withdraw stale approval",
+			concurrency: 1,
+			nodes: []runtime.Object{
+				testNode("node-a", map[string]string{RebootRequiredAnnotation: "2019-04-03T00:00:00Z"}),
+				testNode("node-c", map[string]string{RebootApprovedAnnotation: "2019-04-02T01:00:00Z"}),
+			},
+			approved: []string{"node-a"},
+			status:   Status{InProgress: []string{"node-a"}, Approved: []string{"node-a"}},
+		},
+	}
+
+	for _, tst := range tests {
+		client := fake.NewSimpleClientset(tst.nodes...)
+		perPass := tst.perPass
+		c := New(client, OldestFirst{}, tst.concurrency, func(ctx context.Context, nodes []v1.Node) Eligibility {
+			return &testEligibility{client: client, perPass: perPass}
+		})
+
+		status, err := c.Reconcile(context.Background())
+		if err != nil {
+			t.Errorf("Test %s: Unexpected error: %v", tst.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(approvedNodes(t, client), tst.approved) {
+			t.Errorf("Test %s: Expected approved nodes %v got %v", tst.name, tst.approved, approvedNodes(t, client))
+		}
+		if !reflect.DeepEqual(*status, tst.status) {
+			t.Errorf("Test %s: Expected status %+v got %+v", tst.name, tst.status, *status)
+		}
+	}
+}
+
+func TestStrategies(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2019, 4, d, 0, 0, 0, 0, time.UTC)
+	}
+	candidates := []Candidate{
+		{Node: testNode("node-b", nil), Since: day(1)},
+		{Node: testNode("node-c", nil), Since: day(3)},
+		{Node: testNode("node-a", nil), Since: day(2)},
+		{Node: testNode("node-d", nil), Since: day(1)},
+	}
+
+	names := func(candidates []Candidate) []string {
+		var names []string
+		for _, c := range candidates {
+			names = append(names, c.Node.Name)
+		}
+		return names
+	}
+
+	tests := []struct {
+		strategy string
+		expected []string
+	}{
+		{"oldest-first", []string{"node-b", "node-d", "node-a", "node-c"}},
+		{"by-name", []string{"node-a", "node-b", "node-c", "node-d"}},
+	}
+
+	for _, tst := range tests {
+		strategy, err := NewStrategy(tst.strategy)
+		if err != nil {
+			t.Fatalf("Failed to create strategy %s: %v", tst.strategy, err)
+		}
+		if result := names(strategy.Order(candidates)); !reflect.DeepEqual(result, tst.expected) {
+			t.Errorf("Test %s: Expected %v got %v", tst.strategy, tst.expected, result)
+		}
+	}
+
+	strategy, err := NewStrategy("random")
+	if err != nil {
+		t.Fatalf("Failed to create strategy random: %v", err)
+	}
+	if result := strategy.Order(candidates); len(result) != len(candidates) {
+		t.Errorf("Test random: Expected %d candidates got %d", len(candidates), len(result))
+	}
+
+	if _, err := NewStrategy("fastest"); err == nil {
+		t.Errorf("Expected to receive error for unknown strategy")
+	}
+}
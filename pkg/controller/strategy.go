@@ -0,0 +1,98 @@
+package controller
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// Candidate is a node which requires a reboot.
+type Candidate struct {
+	Node *v1.Node
+	// Since is the time the node first reported that it requires a reboot.
+	Since time.Time
+}
+
+// Strategy decides the order in which nodes requiring a reboot are rebooted.
+type Strategy interface {
+	// Name returns the name of the strategy.
+	Name() string
+	// Order returns the candidates in the order in which they should be
+	// rebooted.
+	Order(candidates []Candidate) []Candidate
+}
+
+// NewStrategy returns the strategy with the given name: oldest-first,
+// by-name or random.
+func NewStrategy(name string) (Strategy, error) {
+	switch name {
+	case "oldest-first":
+		return OldestFirst{}, nil
+	case "by-name":
+		return ByName{}, nil
+	case "random":
+		return &Random{rand: rand.New(rand.NewSource(time.Now().UnixNano()))}, nil
+	default:
+		return nil, fmt.Errorf("Unknown reboot strategy: %q", name)
+	}
+}
+
+// OldestFirst reboots the nodes which have required a reboot for the longest
+// time first.
+type OldestFirst struct{}
+
+// Name implements Strategy.
+func (OldestFirst) Name() string {
+	return "oldest-first"
+}
+
+// Order implements Strategy.
+func (OldestFirst) Order(candidates []Candidate) []Candidate {
+	sorted := append([]Candidate{}, candidates...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Since.Equal(sorted[j].Since) {
+			return sorted[i].Node.Name < sorted[j].Node.Name
+		}
+		return sorted[i].Since.Before(sorted[j].Since)
+	})
+	return sorted
+}
+
+// ByName reboots nodes in the alphabetical order of their names.
+type ByName struct{}
+
+// Name implements Strategy.
+func (ByName) Name() string {
+	return "by-name"
+}
+
+// Order implements Strategy.
+func (ByName) Order(candidates []Candidate) []Candidate {
+	sorted := append([]Candidate{}, candidates...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Node.Name < sorted[j].Node.Name
+	})
+	return sorted
+}
+
+// Random reboots nodes in random order.
+type Random struct {
+	rand *rand.Rand
+}
+
+// Name implements Strategy.
+func (*Random) Name() string {
+	return "random"
+}
+
+// Order implements Strategy.
+func (r *Random) Order(candidates []Candidate) []Candidate {
+	shuffled := append([]Candidate{}, candidates...)
+	r.rand.Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+	return shuffled
+}
@@ -11,10 +11,17 @@ import (
 	"k8s.io/client-go/kubernetes"
 )
 
+var (
+	// ErrNotHeld is returned when releasing or updating a lock nobody holds, e.g. as it was released meanwhile
+	ErrNotHeld = fmt.Errorf("Lock not held")
+	// ErrNotHolder is returned when releasing or updating a lock another node holds, e.g. as it took over the lock once expired
+	ErrNotHolder = fmt.Errorf("Not lock holder")
+)
+
 // DaemonSetLock holds all necessary information to do actions
 // on the kured ds which holds lock info through annotations.
 type DaemonSetLock struct {
-	client     *kubernetes.Clientset
+	client     kubernetes.Interface
 	nodeID     string
 	namespace  string
 	name       string
@@ -29,28 +36,36 @@ type lockAnnotationValue struct {
 }
 
 // New creates a daemonsetLock object containing the necessary data for follow up k8s requests
-func New(client *kubernetes.Clientset, nodeID, namespace, name, annotation string) *DaemonSetLock {
+func New(client kubernetes.Interface, nodeID, namespace, name, annotation string) *DaemonSetLock {
 	return &DaemonSetLock{client, nodeID, namespace, name, annotation}
 }
 
 // Acquire attempts to annotate the kured daemonset with lock info from instantiated DaemonSetLock using client-go
-func (dsl *DaemonSetLock) Acquire(metadata interface{}, TTL time.Duration) (acquired bool, owner string, err error) {
+func (dsl *DaemonSetLock) Acquire(ctx context.Context, metadata interface{}, TTL time.Duration) (acquired bool, owner string, err error) {
+	acquired, owner, _, err = dsl.TakeOver(ctx, metadata, TTL, nil)
+	return acquired, owner, err
+}
+
+// TakeOver is like Acquire, additionally returning the ID of the node whose expired lock was taken over, if any, with its metadata unmarshalled into expiredMetadata
+func (dsl *DaemonSetLock) TakeOver(ctx context.Context, metadata interface{}, TTL time.Duration, expiredMetadata interface{}) (acquired bool, owner string, expired string, err error) {
 	for {
-		ds, err := dsl.client.AppsV1().DaemonSets(dsl.namespace).Get(context.TODO(), dsl.name, metav1.GetOptions{})
+		ds, err := dsl.client.AppsV1().DaemonSets(dsl.namespace).Get(ctx, dsl.name, metav1.GetOptions{})
 		if err != nil {
-			return false, "", err
+			return false, "", "", err
 		}
 
+		expired = ""
 		valueString, exists := ds.ObjectMeta.Annotations[dsl.annotation]
 		if exists {
-			value := lockAnnotationValue{}
+			value := lockAnnotationValue{Metadata: expiredMetadata}
 			if err := json.Unmarshal([]byte(valueString), &value); err != nil {
-				return false, "", err
+				return false, "", "", err
 			}
 
 			if !ttlExpired(value.Created, value.TTL) {
-				return value.NodeID == dsl.nodeID, value.NodeID, nil
+				return value.NodeID == dsl.nodeID, value.NodeID, "", nil
 			}
+			expired = value.NodeID
 		}
 
 		if ds.ObjectMeta.Annotations == nil {
@@ -59,27 +74,29 @@ func (dsl *DaemonSetLock) Acquire(metadata interface{}, TTL time.Duration) (acqu
 		value := lockAnnotationValue{NodeID: dsl.nodeID, Metadata: metadata, Created: time.Now().UTC(), TTL: TTL}
 		valueBytes, err := json.Marshal(&value)
 		if err != nil {
-			return false, "", err
+			return false, "", "", err
 		}
 		ds.ObjectMeta.Annotations[dsl.annotation] = string(valueBytes)
 
-		_, err = dsl.client.AppsV1().DaemonSets(dsl.namespace).Update(context.TODO(), ds, metav1.UpdateOptions{})
+		_, err = dsl.client.AppsV1().DaemonSets(dsl.namespace).Update(ctx, ds, metav1.UpdateOptions{})
 		if err != nil {
 			if se, ok := err.(*errors.StatusError); ok && se.ErrStatus.Reason == metav1.StatusReasonConflict {
 				// Something else updated the resource between us reading and writing - try again soon
-				time.Sleep(time.Second)
+				if err := retryLater(ctx); err != nil {
+					return false, "", "", err
+				}
 				continue
 			} else {
-				return false, "", err
+				return false, "", "", err
 			}
 		}
-		return true, dsl.nodeID, nil
+		return true, dsl.nodeID, expired, nil
 	}
 }
 
 // Test attempts to check the kured daemonset lock status (existence, expiry) from instantiated DaemonSetLock using client-go
-func (dsl *DaemonSetLock) Test(metadata interface{}) (holding bool, err error) {
-	ds, err := dsl.client.AppsV1().DaemonSets(dsl.namespace).Get(context.TODO(), dsl.name, metav1.GetOptions{})
+func (dsl *DaemonSetLock) Test(ctx context.Context, metadata interface{}) (holding bool, err error) {
+	ds, err := dsl.client.AppsV1().DaemonSets(dsl.namespace).Get(ctx, dsl.name, metav1.GetOptions{})
 	if err != nil {
 		return false, err
 	}
@@ -99,10 +116,77 @@ func (dsl *DaemonSetLock) Test(metadata interface{}) (holding bool, err error) {
 	return false, nil
 }
 
+// Holder returns the ID of the node holding the lock of the kured ds, or an empty string if the lock is free or expired
+func (dsl *DaemonSetLock) Holder(ctx context.Context) (string, error) {
+	ds, err := dsl.client.AppsV1().DaemonSets(dsl.namespace).Get(ctx, dsl.name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	valueString, exists := ds.ObjectMeta.Annotations[dsl.annotation]
+	if exists {
+		value := lockAnnotationValue{}
+		if err := json.Unmarshal([]byte(valueString), &value); err != nil {
+			return "", err
+		}
+
+		if !ttlExpired(value.Created, value.TTL) {
+			return value.NodeID, nil
+		}
+	}
+
+	return "", nil
+}
+
+// Update attempts to replace the metadata stored in the lock held by the instantiated DaemonSetLock using client-go
+func (dsl *DaemonSetLock) Update(ctx context.Context, metadata interface{}) error {
+	for {
+		ds, err := dsl.client.AppsV1().DaemonSets(dsl.namespace).Get(ctx, dsl.name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		valueString, exists := ds.ObjectMeta.Annotations[dsl.annotation]
+		if !exists {
+			return ErrNotHeld
+		}
+
+		value := lockAnnotationValue{}
+		if err := json.Unmarshal([]byte(valueString), &value); err != nil {
+			return err
+		}
+
+		if value.NodeID != dsl.nodeID {
+			return fmt.Errorf("%w: %v", ErrNotHolder, value.NodeID)
+		}
+
+		value.Metadata = metadata
+		valueBytes, err := json.Marshal(&value)
+		if err != nil {
+			return err
+		}
+		ds.ObjectMeta.Annotations[dsl.annotation] = string(valueBytes)
+
+		_, err = dsl.client.AppsV1().DaemonSets(dsl.namespace).Update(ctx, ds, metav1.UpdateOptions{})
+		if err != nil {
+			if se, ok := err.(*errors.StatusError); ok && se.ErrStatus.Reason == metav1.StatusReasonConflict {
+				// Something else updated the resource between us reading and writing - try again soon
+				if err := retryLater(ctx); err != nil {
+					return err
+				}
+				continue
+			} else {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
 // Release attempts to remove the lock data from the kured ds annotations using client-go
-func (dsl *DaemonSetLock) Release() error {
+func (dsl *DaemonSetLock) Release(ctx context.Context) error {
 	for {
-		ds, err := dsl.client.AppsV1().DaemonSets(dsl.namespace).Get(context.TODO(), dsl.name, metav1.GetOptions{})
+		ds, err := dsl.client.AppsV1().DaemonSets(dsl.namespace).Get(ctx, dsl.name, metav1.GetOptions{})
 		if err != nil {
 			return err
 		}
@@ -115,19 +199,21 @@ func (dsl *DaemonSetLock) Release() error {
 			}
 
 			if value.NodeID != dsl.nodeID {
-				return fmt.Errorf("Not lock holder: %v", value.NodeID)
+				return fmt.Errorf("%w: %v", ErrNotHolder, value.NodeID)
 			}
 		} else {
-			return fmt.Errorf("Lock not held")
+			return ErrNotHeld
 		}
 
 		delete(ds.ObjectMeta.Annotations, dsl.annotation)
 
-		_, err = dsl.client.AppsV1().DaemonSets(dsl.namespace).Update(context.TODO(), ds, metav1.UpdateOptions{})
+		_, err = dsl.client.AppsV1().DaemonSets(dsl.namespace).Update(ctx, ds, metav1.UpdateOptions{})
 		if err != nil {
 			if se, ok := err.(*errors.StatusError); ok && se.ErrStatus.Reason == metav1.StatusReasonConflict {
 				// Something else updated the resource between us reading and writing - try again soon
-				time.Sleep(time.Second)
+				if err := retryLater(ctx); err != nil {
+					return err
+				}
 				continue
 			} else {
 				return err
@@ -137,6 +223,16 @@ func (dsl *DaemonSetLock) Release() error {
 	}
 }
 
+// retryLater waits a second before trying again, unless ctx is done first.
+func retryLater(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(time.Second):
+		return nil
+	}
+}
+
 func ttlExpired(created time.Time, ttl time.Duration) bool {
 	if ttl > 0 && time.Since(created) >= ttl {
 		return true
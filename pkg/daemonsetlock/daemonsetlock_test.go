@@ -1,8 +1,15 @@
 package daemonsetlock
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"testing"
 	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 func TestTtlExpired(t *testing.T) {
@@ -26,3 +33,106 @@ func TestTtlExpired(t *testing.T) {
 		}
 	}
 }
+
+type testMetadata struct {
+	Unschedulable bool `json:"unschedulable"`
+}
+
+// testDaemonSet returns the kured daemonset, locked by nodeID unless empty.
+func testDaemonSet(t *testing.T, nodeID string, created time.Time, TTL time.Duration) *appsv1.DaemonSet {
+	ds := &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "kured"}}
+	if nodeID != "" {
+		value, err := json.Marshal(&lockAnnotationValue{NodeID: nodeID, Metadata: testMetadata{Unschedulable: true}, Created: created, TTL: TTL})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		ds.Annotations = map[string]string{"lock": string(value)}
+	}
+	return ds
+}
+
+func TestTakeOver(t *testing.T) {
+	expired := time.Now().Add(-2 * time.Hour)
+
+	tests := []struct {
+		name     string
+		ds       *appsv1.DaemonSet
+		acquired bool
+		owner    string
+		expired  string
+	}{
+		{"free lock", testDaemonSet(t, "", time.Time{}, 0), true, "node-a", ""},
+		{"own lock", testDaemonSet(t, "node-a", time.Now(), time.Hour), true, "node-a", ""},
+		{"live lock", testDaemonSet(t, "node-b", time.Now(), time.Hour), false, "node-b", ""},
+		{"lock without ttl", testDaemonSet(t, "node-b", expired, 0), false, "node-b", ""},
+		{"expired lock", testDaemonSet(t, "node-b", expired, time.Hour), true, "node-a", "node-b"},
+	}
+
+	for _, tst := range tests {
+		client := fake.NewSimpleClientset(tst.ds)
+		lock := New(client, "node-a", "kube-system", "kured", "lock")
+		expiredMetadata := &testMetadata{}
+		acquired, owner, expiredNode, err := lock.TakeOver(context.Background(), testMetadata{}, time.Hour, expiredMetadata)
+		if err != nil {
+			t.Errorf("Test %s: Unexpected error: %v", tst.name, err)
+			continue
+		}
+		if acquired != tst.acquired || owner != tst.owner || expiredNode != tst.expired {
+			t.Errorf("Test %s: Expected (%v, %s, %q) got (%v, %s, %q)", tst.name, tst.acquired, tst.owner, tst.expired, acquired, owner, expiredNode)
+		}
+		if tst.expired != "" && !expiredMetadata.Unschedulable {
+			t.Errorf("Test %s: Expected the metadata of the expired lock", tst.name)
+		}
+
+		holder, err := lock.Holder(context.Background())
+		if err != nil {
+			t.Errorf("Test %s: Unexpected error: %v", tst.name, err)
+		} else if holder != tst.owner {
+			t.Errorf("Test %s: Expected holder %s got %s", tst.name, tst.owner, holder)
+		}
+	}
+}
+
+func TestHolder(t *testing.T) {
+	tests := []struct {
+		name   string
+		ds     *appsv1.DaemonSet
+		holder string
+	}{
+		{"no annotation", testDaemonSet(t, "", time.Time{}, 0), ""},
+		{"live lock", testDaemonSet(t, "node-b", time.Now(), time.Hour), "node-b"},
+		{"expired lock", testDaemonSet(t, "node-b", time.Now().Add(-2*time.Hour), time.Hour), ""},
+	}
+
+	for _, tst := range tests {
+		lock := New(fake.NewSimpleClientset(tst.ds), "node-a", "kube-system", "kured", "lock")
+		holder, err := lock.Holder(context.Background())
+		if err != nil {
+			t.Errorf("Test %s: Unexpected error: %v", tst.name, err)
+		} else if holder != tst.holder {
+			t.Errorf("Test %s: Expected holder %q got %q", tst.name, tst.holder, holder)
+		}
+	}
+}
+
+func TestUpdateAndRelease(t *testing.T) {
+	tests := []struct {
+		name string
+		ds   *appsv1.DaemonSet
+		err  error
+	}{
+		{"own lock", testDaemonSet(t, "node-a", time.Now(), time.Hour), nil},
+		{"lock held by another node", testDaemonSet(t, "node-b", time.Now(), time.Hour), ErrNotHolder},
+		{"no lock", testDaemonSet(t, "", time.Time{}, 0), ErrNotHeld},
+	}
+
+	for _, tst := range tests {
+		lock := New(fake.NewSimpleClientset(tst.ds), "node-a", "kube-system", "kured", "lock")
+		if err := lock.Update(context.Background(), testMetadata{}); !errors.Is(err, tst.err) {
+			t.Errorf("Test %s: Expected update error %v got %v", tst.name, tst.err, err)
+		}
+		if err := lock.Release(context.Background()); !errors.Is(err, tst.err) {
+			t.Errorf("Test %s: Expected release error %v got %v", tst.name, tst.err, err)
+		}
+	}
+}
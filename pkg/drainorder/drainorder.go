@@ -0,0 +1,118 @@
+package drainorder
+
+import (
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// Tier names a group of pods which are evicted together during a drain.
+type Tier string
+
+const (
+	// Stateless pods are neither owned by a StatefulSet nor mount a persistent volume claim.
+	Stateless Tier = "stateless"
+	// Stateful pods are owned by a StatefulSet or mount a persistent volume claim.
+	Stateful Tier = "stateful"
+	// Last pods carry the drain-last annotation or run with a high priority.
+	Last Tier = "last"
+)
+
+// Classifier assigns pods to tiers.
+type Classifier struct {
+	// LastAnnotation is the annotation which places a pod into the Last tier
+	// when set to "true". Disabled if empty.
+	LastAnnotation string
+	// LastPriority is the pod priority from which a pod is placed into the
+	// Last tier. Disabled if zero.
+	LastPriority int32
+}
+
+// ParseTiers validates a list of tier names and returns them in order.
+func ParseTiers(names []string) ([]Tier, error) {
+	var tiers []Tier
+	seen := make(map[Tier]bool)
+	for _, name := range names {
+		if len(name) == 0 {
+			continue
+		}
+
+		tier := Tier(strings.ToLower(name))
+		switch tier {
+		case Stateless, Stateful, Last:
+		default:
+			return nil, fmt.Errorf("Invalid drain tier: %s", name)
+		}
+
+		if seen[tier] {
+			return nil, fmt.Errorf("Duplicate drain tier: %s", name)
+		}
+		seen[tier] = true
+		tiers = append(tiers, tier)
+	}
+
+	return tiers, nil
+}
+
+// Classify returns the tier of a pod.
+func (c *Classifier) Classify(pod v1.Pod) Tier {
+	if c.LastAnnotation != "" && strings.ToLower(pod.Annotations[c.LastAnnotation]) == "true" {
+		return Last
+	}
+
+	if c.LastPriority != 0 && pod.Spec.Priority != nil && *pod.Spec.Priority >= c.LastPriority {
+		return Last
+	}
+
+	for _, ref := range pod.OwnerReferences {
+		if ref.Kind == "StatefulSet" {
+			return Stateful
+		}
+	}
+
+	for _, volume := range pod.Spec.Volumes {
+		if volume.PersistentVolumeClaim != nil {
+			return Stateful
+		}
+	}
+
+	return Stateless
+}
+
+// Group is a set of pods which are evicted together.
+type Group struct {
+	Name string
+	Pods []v1.Pod
+}
+
+// Partition splits pods into groups following the order of tiers. Pods
+// belonging to tiers which are not listed are collected into a final group
+// named "other", so that every pod is evicted exactly once. Empty groups are
+// omitted.
+func (c *Classifier) Partition(pods []v1.Pod, tiers []Tier) []Group {
+	groups := make([]Group, len(tiers)+1)
+	index := make(map[Tier]int)
+	for i, tier := range tiers {
+		groups[i].Name = string(tier)
+		index[tier] = i
+	}
+	groups[len(tiers)].Name = "other"
+
+	for _, pod := range pods {
+		i, ok := index[c.Classify(pod)]
+		if !ok {
+			i = len(tiers)
+		}
+		groups[i].Pods = append(groups[i].Pods, pod)
+	}
+
+	var result []Group
+	for _, group := range groups {
+		if len(group.Pods) > 0 {
+			result = append(result, group)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,116 @@
+package drainorder
+
+import (
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseTiers(t *testing.T) {
+	tests := []struct {
+		input  string
+		result string
+		err    bool
+	}{
+		{"stateless,stateful,last", "stateless,stateful,last", false},
+		{"Last,STATELESS", "last,stateless", false},
+		{"", "", false},
+		{",,", "", false},
+		{"stateless,bogus", "", true},
+		{"last,last", "", true},
+	}
+
+	for _, tst := range tests {
+		tiers, err := ParseTiers(strings.Split(tst.input, ","))
+		if tst.err {
+			if err == nil {
+				t.Errorf("Expected to receive error for input %s", tst.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Received error for input %s: %v", tst.input, err)
+			continue
+		}
+
+		var names []string
+		for _, tier := range tiers {
+			names = append(names, string(tier))
+		}
+		if strings.Join(names, ",") != tst.result {
+			t.Errorf("Test %s: Expected %s got %v", tst.input, tst.result, names)
+		}
+	}
+}
+
+func TestClassify(t *testing.T) {
+	high := int32(1000)
+	low := int32(10)
+
+	tests := []struct {
+		name string
+		pod  v1.Pod
+		tier Tier
+	}{
+		{"plain", v1.Pod{}, Stateless},
+		{"statefulset", v1.Pod{ObjectMeta: metav1.ObjectMeta{
+			OwnerReferences: []metav1.OwnerReference{{Kind: "StatefulSet"}}}}, Stateful},
+		{"pvc", v1.Pod{Spec: v1.PodSpec{Volumes: []v1.Volume{{
+			VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{}}}}}}, Stateful},
+		{"annotated", v1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Annotations:     map[string]string{"drain-last": "true"},
+			OwnerReferences: []metav1.OwnerReference{{Kind: "StatefulSet"}}}}, Last},
+		{"annotated false", v1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{"drain-last": "false"}}}, Stateless},
+		{"high priority", v1.Pod{Spec: v1.PodSpec{Priority: &high}}, Last},
+		{"low priority", v1.Pod{Spec: v1.PodSpec{Priority: &low}}, Stateless},
+	}
+
+	c := &Classifier{LastAnnotation: "drain-last", LastPriority: 100}
+	for _, tst := range tests {
+		if tier := c.Classify(tst.pod); tier != tst.tier {
+			t.Errorf("Test %s: Expected %s got %s", tst.name, tst.tier, tier)
+		}
+	}
+}
+
+func TestPartition(t *testing.T) {
+	pod := func(name string, kind string) v1.Pod {
+		p := v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		if kind != "" {
+			p.OwnerReferences = []metav1.OwnerReference{{Kind: kind}}
+		}
+		if name == "last" {
+			p.Annotations = map[string]string{"drain-last": "true"}
+		}
+		return p
+	}
+	pods := []v1.Pod{pod("db", "StatefulSet"), pod("web", "ReplicaSet"), pod("last", ""), pod("api", "")}
+
+	tests := []struct {
+		tiers  []Tier
+		result string
+	}{
+		{[]Tier{Stateless, Stateful, Last}, "stateless[web api] stateful[db] last[last]"},
+		{[]Tier{Stateful}, "stateful[db] other[web last api]"},
+		{[]Tier{Last, Stateless}, "last[last] stateless[web api] other[db]"},
+		{nil, "other[db web last api]"},
+	}
+
+	c := &Classifier{LastAnnotation: "drain-last"}
+	for _, tst := range tests {
+		var groups []string
+		for _, group := range c.Partition(pods, tst.tiers) {
+			var names []string
+			for _, p := range group.Pods {
+				names = append(names, p.Name)
+			}
+			groups = append(groups, group.Name+"["+strings.Join(names, " ")+"]")
+		}
+		if strings.Join(groups, " ") != tst.result {
+			t.Errorf("Test %v: Expected %s got %v", tst.tiers, tst.result, groups)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package errorclass
+
+import (
+	"errors"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	"github.com/weaveworks/kured/pkg/daemonsetlock"
+)
+
+// Class tells how to deal with an error of an API operation.
+type Class string
+
+const (
+	// Transient errors, e.g. while the apiserver is briefly unavailable, go
+	// away by themselves; the operation is retried after a while.
+	Transient Class = "transient"
+	// Conflict errors are caused by a concurrent update; the operation is
+	// retried right away on the object read again.
+	Conflict Class = "conflict"
+	// Fatal errors will not go away by retrying, e.g. missing permissions.
+	Fatal Class = "fatal"
+	// Stale errors are caused by state kured kept no longer being current,
+	// e.g. a lock which expired and was taken over; retrying will not help,
+	// but kured carries on without that state.
+	Stale Class = "stale"
+)
+
+// Classify determines the class of err. Only API statuses known to be
+// permanent are fatal; anything else, in particular network errors, may go
+// away and is transient.
+func Classify(err error) Class {
+	switch {
+	case err == nil:
+		return ""
+	case apierrors.IsConflict(err):
+		return Conflict
+	case errors.Is(err, daemonsetlock.ErrNotHeld), errors.Is(err, daemonsetlock.ErrNotHolder):
+		return Stale
+	case apierrors.IsForbidden(err),
+		apierrors.IsUnauthorized(err),
+		apierrors.IsInvalid(err),
+		apierrors.IsBadRequest(err),
+		apierrors.IsNotFound(err):
+		return Fatal
+	}
+	return Transient
+}
+
+// Denied determines whether err results from kured not being authenticated
+// or authorized for the operation, which only fixing its configuration or
+// permissions resolves.
+func Denied(err error) bool {
+	return apierrors.IsForbidden(err) || apierrors.IsUnauthorized(err)
+}
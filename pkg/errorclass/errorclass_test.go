@@ -0,0 +1,80 @@
+package errorclass
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/weaveworks/kured/pkg/daemonsetlock"
+)
+
+func TestClassify(t *testing.T) {
+	resource := schema.GroupResource{Group: "apps", Resource: "daemonsets"}
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: &os.SyscallError{Syscall: "connect", Err: syscall.ECONNREFUSED}}
+	unreachable := &url.Error{Op: "Get", URL: "https://10.96.0.1:443/api/v1/nodes/node-a", Err: &net.OpError{Op: "dial", Net: "tcp", Err: &os.SyscallError{Syscall: "connect", Err: syscall.EHOSTUNREACH}}}
+	unresolved := &url.Error{Op: "Get", URL: "https://kubernetes.default.svc/api/v1/nodes/node-a", Err: &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "kubernetes.default.svc", IsNotFound: true}}}
+
+	tests := []struct {
+		name  string
+		err   error
+		class Class
+	}{
+		{"nil", nil, ""},
+		{"conflict", apierrors.NewConflict(resource, "kured", errors.New("modified")), Conflict},
+		{"server timeout", apierrors.NewServerTimeout(resource, "update", 1), Transient},
+		{"timeout", apierrors.NewTimeoutError("timed out", 1), Transient},
+		{"too many requests", apierrors.NewTooManyRequests("slow down", 1), Transient},
+		{"service unavailable", apierrors.NewServiceUnavailable("unavailable"), Transient},
+		{"internal error", apierrors.NewInternalError(errors.New("boom")), Transient},
+		{"deadline exceeded", fmt.Errorf("Error reading node: %w", context.DeadlineExceeded), Transient},
+		{"unexpected EOF", io.ErrUnexpectedEOF, Transient},
+		{"connection refused", refused, Transient},
+		{"no route to host", unreachable, Transient},
+		{"no such host", unresolved, Transient},
+		{"forbidden", apierrors.NewForbidden(resource, "kured", errors.New("denied")), Fatal},
+		{"unauthorized", apierrors.NewUnauthorized("no credentials"), Fatal},
+		{"invalid", apierrors.NewInvalid(schema.GroupKind{Group: "apps", Kind: "DaemonSet"}, "kured", nil), Fatal},
+		{"bad request", apierrors.NewBadRequest("malformed"), Fatal},
+		{"not found", apierrors.NewNotFound(resource, "kured"), Fatal},
+		{"lock not held", fmt.Errorf("Error releasing lock: %w", daemonsetlock.ErrNotHeld), Stale},
+		{"not lock holder", fmt.Errorf("%w: node-b", daemonsetlock.ErrNotHolder), Stale},
+		{"other", errors.New("global timeout reached: 5m0s"), Transient},
+	}
+
+	for _, tst := range tests {
+		if class := Classify(tst.err); class != tst.class {
+			t.Errorf("Test %s: Expected %q got %q", tst.name, tst.class, class)
+		}
+	}
+}
+
+func TestDenied(t *testing.T) {
+	resource := schema.GroupResource{Resource: "pods"}
+
+	tests := []struct {
+		name   string
+		err    error
+		denied bool
+	}{
+		{"forbidden", apierrors.NewForbidden(resource, "web", errors.New("denied")), true},
+		{"unauthorized", apierrors.NewUnauthorized("no credentials"), true},
+		{"wrapped", fmt.Errorf("Error cordonning node-a: %w", apierrors.NewForbidden(resource, "web", errors.New("denied"))), true},
+		{"drain timeout", errors.New("error when evicting pods/\"web\" -n \"default\": global timeout reached: 5m0s"), false},
+		{"not found", apierrors.NewNotFound(resource, "web"), false},
+	}
+
+	for _, tst := range tests {
+		if denied := Denied(tst.err); denied != tst.denied {
+			t.Errorf("Test %s: Expected %v got %v", tst.name, tst.denied, denied)
+		}
+	}
+}
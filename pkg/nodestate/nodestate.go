@@ -0,0 +1,188 @@
+package nodestate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// State is the progress of a node through a reboot.
+type State string
+
+// The states of a node. A node is Idle until it requires a reboot, and
+// passes through WaitingForLock, Draining and Rebooting to Verifying once it
+// is back, ending up Done or Failed.
+const (
+	// Unknown is the state of nodes kured has not recorded a state for.
+	Unknown        State = ""
+	Idle           State = "Idle"
+	RebootRequired State = "RebootRequired"
+	WaitingForLock State = "WaitingForLock"
+	Draining       State = "Draining"
+	Rebooting      State = "Rebooting"
+	Verifying      State = "Verifying"
+	Done           State = "Done"
+	Failed         State = "Failed"
+)
+
+// Label and Annotation of the node record its state; the annotation also
+// records since when, and the states passed through during the current
+// reboot.
+const (
+	Label      = "kured.dev/state"
+	Annotation = "kured.dev/state"
+)
+
+// maxHistory limits the number of transitions kept in a Record.
+const maxHistory = 10
+
+// transitions are the valid successors of each state.
+var transitions = map[State][]State{
+	Idle:           {RebootRequired},
+	RebootRequired: {Idle, WaitingForLock, Draining, Rebooting},
+	WaitingForLock: {Idle, RebootRequired, Draining, Rebooting},
+	Draining:       {RebootRequired, Rebooting, Failed},
+	Rebooting:      {Verifying},
+	Verifying:      {Done, Failed},
+	Done:           {Idle, RebootRequired},
+	Failed:         {Idle, RebootRequired},
+}
+
+// Valid determines whether a node may pass from one state to another. Any
+// state may follow Unknown, and every state may be entered again.
+func Valid(from, to State) bool {
+	if from == Unknown || from == to {
+		return true
+	}
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Transition is the entry of a node into a state.
+type Transition struct {
+	State   State     `json:"state"`
+	Time    time.Time `json:"time"`
+	Message string    `json:"message,omitempty"`
+}
+
+// Record is the content of the annotation.
+type Record struct {
+	Transition `json:",inline"`
+	// History are the earlier transitions of the current reboot, oldest
+	// first.
+	History []Transition `json:"history,omitempty"`
+}
+
+// Current returns the record of the state of the node.
+func Current(node *v1.Node) (*Record, error) {
+	value, ok := node.Annotations[Annotation]
+	if !ok {
+		return &Record{}, nil
+	}
+	record := &Record{}
+	if err := json.Unmarshal([]byte(value), record); err != nil {
+		return nil, fmt.Errorf("Invalid %s annotation: %v", Annotation, err)
+	}
+	return record, nil
+}
+
+// next records the transition into state at t, starting a new history when
+// a new reboot begins. Entering the current state again only updates the
+// message.
+func (r *Record) next(state State, message string, t time.Time) {
+	if r.State == state {
+		r.Message = message
+		return
+	}
+	if r.State != Unknown {
+		if state == RebootRequired && (r.State == Idle || r.State == Done || r.State == Failed) {
+			r.History = nil
+		} else {
+			r.History = append(r.History, r.Transition)
+			if len(r.History) > maxHistory {
+				r.History = r.History[len(r.History)-maxHistory:]
+			}
+		}
+	}
+	r.Transition = Transition{State: state, Time: t.UTC(), Message: message}
+}
+
+// Machine records the transitions of a node between states.
+type Machine struct {
+	client kubernetes.Interface
+	nodeID string
+	record *Record
+}
+
+// New creates a machine for the node, starting from its recorded state.
+// An invalid record is disregarded.
+func New(client kubernetes.Interface, node *v1.Node) (*Machine, error) {
+	record, err := Current(node)
+	if err != nil {
+		return &Machine{client: client, nodeID: node.Name, record: &Record{}}, err
+	}
+	return &Machine{client: client, nodeID: node.Name, record: record}, nil
+}
+
+// State returns the current state.
+func (m *Machine) State() State {
+	return m.record.State
+}
+
+// Since returns the time the current state was entered.
+func (m *Machine) Since() time.Time {
+	return m.record.Time
+}
+
+// Transition moves the node into state, failing if the transition is not
+// valid. Entering the current state again with the same message has no
+// effect.
+func (m *Machine) Transition(ctx context.Context, state State, message string) error {
+	if !Valid(m.record.State, state) {
+		return fmt.Errorf("Invalid transition of node %s from %s to %s", m.nodeID, m.record.State, state)
+	}
+	return m.set(ctx, state, message)
+}
+
+// Reset moves the node into state regardless of whether the transition is
+// valid, e.g. when a reboot in progress has been abandoned.
+func (m *Machine) Reset(ctx context.Context, state State, message string) error {
+	return m.set(ctx, state, message)
+}
+
+func (m *Machine) set(ctx context.Context, state State, message string) error {
+	if m.record.State == state && m.record.Message == message {
+		return nil
+	}
+	record := &Record{Transition: m.record.Transition, History: append([]Transition{}, m.record.History...)}
+	record.next(state, message, time.Now())
+
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels":      map[string]string{Label: string(state)},
+			"annotations": map[string]string{Annotation: string(value)},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := m.client.CoreV1().Nodes().Patch(ctx, m.nodeID, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return err
+	}
+	m.record = record
+	return nil
+}
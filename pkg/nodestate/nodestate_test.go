@@ -0,0 +1,128 @@
+package nodestate
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		from     State
+		to       State
+		expected bool
+	}{
+		{Unknown, Verifying, true},
+		{Idle, RebootRequired, true},
+		{Idle, Draining, false},
+		{RebootRequired, WaitingForLock, true},
+		{WaitingForLock, Draining, true},
+		{Draining, Draining, true},
+		{Draining, RebootRequired, true},
+		{Draining, Idle, false},
+		{Rebooting, Verifying, true},
+		{Rebooting, Done, false},
+		{Verifying, Done, true},
+		{Verifying, Failed, true},
+		{Done, Idle, true},
+		{Failed, RebootRequired, true},
+	}
+	for _, tst := range tests {
+		if valid := Valid(tst.from, tst.to); valid != tst.expected {
+			t.Errorf("Test %s to %s: Expected %v got %v", tst.from, tst.to, tst.expected, valid)
+		}
+	}
+}
+
+func states(transitions []Transition) []State {
+	var states []State
+	for _, transition := range transitions {
+		states = append(states, transition.State)
+	}
+	return states
+}
+
+func TestMachine(t *testing.T) {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}}
+	client := fake.NewSimpleClientset(node)
+
+	machine, err := New(client, node)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if machine.State() != Unknown {
+		t.Errorf("Expected unknown state, got %s", machine.State())
+	}
+
+	for _, state := range []State{Idle, RebootRequired, WaitingForLock, Draining, Rebooting} {
+		if err := machine.Transition(context.Background(), state, ""); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if err := machine.Transition(context.Background(), Done, ""); err == nil {
+		t.Errorf("Expected error for transition from Rebooting to Done")
+	}
+
+	// A restarted kured resumes from the recorded state
+	updated, err := client.CoreV1().Nodes().Get(context.TODO(), "node-a", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if updated.Labels[Label] != string(Rebooting) {
+		t.Errorf("Expected label %s got %s", Rebooting, updated.Labels[Label])
+	}
+	machine, err = New(client, updated)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if machine.State() != Rebooting {
+		t.Errorf("Expected state %s got %s", Rebooting, machine.State())
+	}
+	if err := machine.Transition(context.Background(), Verifying, "waiting for node to become ready"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	since := machine.Since()
+	if err := machine.Transition(context.Background(), Verifying, "checking critical-alerts"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !machine.Since().Equal(since) {
+		t.Errorf("Expected new message to keep the time the state was entered")
+	}
+	if err := machine.Transition(context.Background(), Done, ""); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := []State{RebootRequired, WaitingForLock, Draining, Rebooting, Verifying}
+	if history := states(machine.record.History); len(history) != len(expected) {
+		t.Errorf("Expected history %v got %v", expected, history)
+	}
+
+	// The next reboot starts a new history
+	if err := machine.Transition(context.Background(), RebootRequired, ""); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(machine.record.History) != 0 {
+		t.Errorf("Expected empty history, got %v", states(machine.record.History))
+	}
+
+	if err := machine.Reset(context.Background(), Verifying, "forced"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if machine.State() != Verifying {
+		t.Errorf("Expected state %s got %s", Verifying, machine.State())
+	}
+}
+
+func TestInvalidRecord(t *testing.T) {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a", Annotations: map[string]string{Annotation: "{"}}}
+	machine, err := New(fake.NewSimpleClientset(node), node)
+	if err == nil {
+		t.Errorf("Expected error for invalid annotation")
+	}
+	if machine.State() != Unknown {
+		t.Errorf("Expected unknown state, got %s", machine.State())
+	}
+}
@@ -52,3 +52,15 @@ func NotifyDrain(hookURL, username, channel, messageTemplate, nodeID string) err
 func NotifyReboot(hookURL, username, channel, messageTemplate, nodeID string) error {
 	return notify(hookURL, username, channel, fmt.Sprintf(messageTemplate, nodeID))
 }
+
+// NotifyRolloutHalted is the exposed way to notify onto a slack chan that a
+// node is unhealthy after its reboot, halting the rollout
+func NotifyRolloutHalted(hookURL, username, channel, messageTemplate, nodeID, reason string) error {
+	return notify(hookURL, username, channel, fmt.Sprintf(messageTemplate, nodeID, reason))
+}
+
+// NotifyCircuitBreaker is the exposed way to notify onto a slack chan that
+// the circuit breaker tripped, stopping all reboots
+func NotifyCircuitBreaker(hookURL, username, channel, messageTemplate, reason string) error {
+	return notify(hookURL, username, channel, fmt.Sprintf(messageTemplate, reason))
+}
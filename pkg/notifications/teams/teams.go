@@ -6,12 +6,12 @@ import (
 	"github.com/dasrick/go-teams-notify/v2"
 )
 
-func notify(hookURL, message string) error {
+func notify(hookURL, title, message string) error {
 
 	mstClient := goteamsnotify.NewClient()
 
 	msgCard := goteamsnotify.NewMessageCard()
-	msgCard.Title = "Node is rebooting"
+	msgCard.Title = title
 	msgCard.Text = message
 	msgCard.ThemeColor = "#3D4ADF"
 
@@ -20,10 +20,22 @@ func notify(hookURL, message string) error {
 
 // NotifyDrain is the exposed way to notify of a drain event onto a slack chan
 func NotifyDrain(hookURL, messageTemplate, nodeID string) error {
-	return notify(hookURL, fmt.Sprintf(messageTemplate, nodeID))
+	return notify(hookURL, "Node is rebooting", fmt.Sprintf(messageTemplate, nodeID))
 }
 
 // NotifyReboot is the exposed way to notify of a reboot event onto a slack chan
 func NotifyReboot(hookURL, messageTemplate, nodeID string) error {
-	return notify(hookURL, fmt.Sprintf(messageTemplate, nodeID))
+	return notify(hookURL, "Node is rebooting", fmt.Sprintf(messageTemplate, nodeID))
+}
+
+// NotifyRolloutHalted is the exposed way to notify onto a teams chan that a
+// node is unhealthy after its reboot, halting the rollout
+func NotifyRolloutHalted(hookURL, messageTemplate, nodeID, reason string) error {
+	return notify(hookURL, "Reboot rollout halted", fmt.Sprintf(messageTemplate, nodeID, reason))
+}
+
+// NotifyCircuitBreaker is the exposed way to notify onto a teams chan that
+// the circuit breaker tripped, stopping all reboots
+func NotifyCircuitBreaker(hookURL, messageTemplate, reason string) error {
+	return notify(hookURL, "Reboots stopped", fmt.Sprintf(messageTemplate, reason))
 }
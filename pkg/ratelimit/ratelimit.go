@@ -0,0 +1,208 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ResetAnnotation on the kured ds asks for the circuit breaker to be reset.
+const ResetAnnotation = "weave.works/kured-circuit-breaker-reset"
+
+// retention is how long reboots are remembered, which is the longest
+// period limited.
+const retention = 24 * time.Hour
+
+// Limits restrict how many reboots may happen across the cluster. Zero
+// values disable the respective limit.
+type Limits struct {
+	PerHour int
+	PerDay  int
+	// MaxConsecutiveFailures trips the circuit breaker once this many
+	// reboots failed in a row.
+	MaxConsecutiveFailures int
+}
+
+// Reboot records a reboot started in the cluster.
+type Reboot struct {
+	Node string    `json:"node"`
+	Time time.Time `json:"time"`
+}
+
+// Trip records why and when the circuit breaker tripped.
+type Trip struct {
+	Time   time.Time `json:"time"`
+	Reason string    `json:"reason"`
+}
+
+// History is the record of recent reboots kept in the cluster.
+type History struct {
+	Reboots             []Reboot `json:"reboots,omitempty"`
+	ConsecutiveFailures int      `json:"consecutiveFailures,omitempty"`
+	// Tripped is set while the circuit breaker is tripped.
+	Tripped *Trip `json:"tripped,omitempty"`
+}
+
+// RecordReboot records that node starts rebooting at t, forgetting reboots
+// too old to matter.
+func (h *History) RecordReboot(node string, t time.Time) {
+	var kept []Reboot
+	for _, r := range h.Reboots {
+		if t.Sub(r.Time) < retention {
+			kept = append(kept, r)
+		}
+	}
+	h.Reboots = append(kept, Reboot{Node: node, Time: t.UTC()})
+}
+
+// RecordOutcome records whether a reboot failed, tripping the circuit
+// breaker once the limits allow no more failures in a row. Returns whether
+// the breaker tripped.
+func (h *History) RecordOutcome(node string, failed bool, t time.Time, limits Limits) bool {
+	if !failed {
+		h.ConsecutiveFailures = 0
+		return false
+	}
+	h.ConsecutiveFailures++
+	if limits.MaxConsecutiveFailures > 0 && h.ConsecutiveFailures >= limits.MaxConsecutiveFailures && h.Tripped == nil {
+		h.Tripped = &Trip{
+			Time:   t.UTC(),
+			Reason: fmt.Sprintf("%d reboots failed in a row, the last of node %s", h.ConsecutiveFailures, node),
+		}
+		return true
+	}
+	return false
+}
+
+// Reset resets the circuit breaker.
+func (h *History) Reset() {
+	h.ConsecutiveFailures = 0
+	h.Tripped = nil
+}
+
+// Count returns the number of reboots started since t.
+func (h *History) Count(since time.Time) int {
+	count := 0
+	for _, r := range h.Reboots {
+		if !r.Time.Before(since) {
+			count++
+		}
+	}
+	return count
+}
+
+// Check determines whether another reboot may start at now, and if not,
+// why.
+func (h *History) Check(limits Limits, now time.Time) (bool, string) {
+	if h.Tripped != nil {
+		return true, fmt.Sprintf("circuit breaker tripped at %v: %s", h.Tripped.Time, h.Tripped.Reason)
+	}
+	if limits.PerHour > 0 {
+		if count := h.Count(now.Add(-time.Hour)); count >= limits.PerHour {
+			return true, fmt.Sprintf("%d reboots in the last hour, limit is %d", count, limits.PerHour)
+		}
+	}
+	if limits.PerDay > 0 {
+		if count := h.Count(now.Add(-24 * time.Hour)); count >= limits.PerDay {
+			return true, fmt.Sprintf("%d reboots in the last day, limit is %d", count, limits.PerDay)
+		}
+	}
+	return false, ""
+}
+
+// Store keeps the History in an annotation of the kured ds, next to the
+// lock.
+type Store struct {
+	client     kubernetes.Interface
+	namespace  string
+	name       string
+	annotation string
+}
+
+// NewStore creates a Store keeping the History in annotation of the ds.
+func NewStore(client kubernetes.Interface, namespace, name, annotation string) *Store {
+	return &Store{client: client, namespace: namespace, name: name, annotation: annotation}
+}
+
+// Load returns the History, resetting the circuit breaker first if this
+// was requested through ResetAnnotation.
+func (s *Store) Load(ctx context.Context) (*History, error) {
+	ds, err := s.client.AppsV1().DaemonSets(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if _, reset := ds.ObjectMeta.Annotations[ResetAnnotation]; reset {
+		var history *History
+		err := s.Update(ctx, func(h *History) error {
+			h.Reset()
+			history = h
+			return nil
+		})
+		return history, err
+	}
+	return parse(ds.ObjectMeta.Annotations[s.annotation])
+}
+
+// Update applies change to the History, retrying on conflicting updates of
+// the ds until ctx is done. A pending reset request is honoured and cleared.
+func (s *Store) Update(ctx context.Context, change func(h *History) error) error {
+	for {
+		ds, err := s.client.AppsV1().DaemonSets(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		history, err := parse(ds.ObjectMeta.Annotations[s.annotation])
+		if err != nil {
+			return err
+		}
+		if _, reset := ds.ObjectMeta.Annotations[ResetAnnotation]; reset {
+			history.Reset()
+			delete(ds.ObjectMeta.Annotations, ResetAnnotation)
+		}
+		if err := change(history); err != nil {
+			return err
+		}
+
+		valueBytes, err := json.Marshal(history)
+		if err != nil {
+			return err
+		}
+		if ds.ObjectMeta.Annotations == nil {
+			ds.ObjectMeta.Annotations = make(map[string]string)
+		}
+		ds.ObjectMeta.Annotations[s.annotation] = string(valueBytes)
+
+		_, err = s.client.AppsV1().DaemonSets(s.namespace).Update(ctx, ds, metav1.UpdateOptions{})
+		if err != nil {
+			if se, ok := err.(*errors.StatusError); ok && se.ErrStatus.Reason == metav1.StatusReasonConflict {
+				// Something else updated the resource between us reading and writing - try again soon
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(time.Second):
+				}
+				continue
+			} else {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func parse(value string) (*History, error) {
+	history := &History{}
+	if value == "" {
+		return history, nil
+	}
+	if err := json.Unmarshal([]byte(value), history); err != nil {
+		return nil, fmt.Errorf("Invalid reboot history: %v", err)
+	}
+	return history, nil
+}
@@ -0,0 +1,132 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCheck(t *testing.T) {
+	now := time.Date(2021, 3, 2, 12, 0, 0, 0, time.UTC)
+	history := &History{}
+	for _, ago := range []time.Duration{30 * time.Hour, 20 * time.Hour, 5 * time.Hour, 50 * time.Minute, 10 * time.Minute} {
+		history.RecordReboot("node", now.Add(-ago))
+	}
+
+	tests := []struct {
+		name    string
+		limits  Limits
+		blocked bool
+		reason  string
+	}{
+		{"no limits", Limits{}, false, ""},
+		{"below hourly limit", Limits{PerHour: 3}, false, ""},
+		{"hourly limit", Limits{PerHour: 2}, true, "2 reboots in the last hour, limit is 2"},
+		{"below daily limit", Limits{PerDay: 5}, false, ""},
+		{"daily limit", Limits{PerHour: 10, PerDay: 4}, true, "4 reboots in the last day, limit is 4"},
+	}
+
+	for _, tst := range tests {
+		blocked, reason := history.Check(tst.limits, now)
+		if blocked != tst.blocked || reason != tst.reason {
+			t.Errorf("Test %s: Expected %v %q got %v %q", tst.name, tst.blocked, tst.reason, blocked, reason)
+		}
+	}
+
+	if len(history.Reboots) != 4 {
+		t.Errorf("Expected reboots older than a day to be forgotten, got %v", history.Reboots)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Date(2021, 3, 2, 12, 0, 0, 0, time.UTC)
+	limits := Limits{MaxConsecutiveFailures: 2}
+	history := &History{}
+
+	if history.RecordOutcome("node-a", true, now, limits) {
+		t.Errorf("Expected breaker not to trip after one failure")
+	}
+	if history.RecordOutcome("node-b", false, now, limits) {
+		t.Errorf("Expected breaker not to trip after success")
+	}
+	if history.RecordOutcome("node-c", true, now, limits) {
+		t.Errorf("Expected breaker not to trip after success and one failure")
+	}
+	if !history.RecordOutcome("node-d", true, now, limits) {
+		t.Errorf("Expected breaker to trip after two failures in a row")
+	}
+	if history.RecordOutcome("node-e", true, now, limits) {
+		t.Errorf("Expected breaker to trip only once")
+	}
+
+	blocked, reason := history.Check(limits, now)
+	expected := "circuit breaker tripped at 2021-03-02 12:00:00 +0000 UTC: 2 reboots failed in a row, the last of node node-d"
+	if !blocked || reason != expected {
+		t.Errorf("Expected %q got %v %q", expected, blocked, reason)
+	}
+
+	history.Reset()
+	if blocked, reason := history.Check(limits, now); blocked {
+		t.Errorf("Expected reset breaker not to block, got %q", reason)
+	}
+}
+
+func TestStore(t *testing.T) {
+	ds := &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "kured"}}
+	client := fake.NewSimpleClientset(ds)
+	store := NewStore(client, "kube-system", "kured", "weave.works/kured-reboot-history")
+	now := time.Date(2021, 3, 2, 12, 0, 0, 0, time.UTC)
+
+	history, err := store.Load(context.TODO())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(history.Reboots) != 0 || history.Tripped != nil {
+		t.Errorf("Expected empty history, got %+v", history)
+	}
+
+	if err := store.Update(context.TODO(), func(h *History) error {
+		h.RecordReboot("node-a", now)
+		h.RecordOutcome("node-a", true, now, Limits{MaxConsecutiveFailures: 1})
+		return nil
+	}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	history, err = store.Load(context.TODO())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(history.Reboots) != 1 || history.Reboots[0].Node != "node-a" || history.Tripped == nil {
+		t.Errorf("Expected stored reboot and trip, got %+v", history)
+	}
+
+	// Request a reset, as an operator would
+	current, err := client.AppsV1().DaemonSets("kube-system").Get(context.TODO(), "kured", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	current.Annotations[ResetAnnotation] = "true"
+	if _, err := client.AppsV1().DaemonSets("kube-system").Update(context.TODO(), current, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	history, err = store.Load(context.TODO())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if history.Tripped != nil || history.ConsecutiveFailures != 0 || len(history.Reboots) != 1 {
+		t.Errorf("Expected reset breaker keeping reboots, got %+v", history)
+	}
+	current, err = client.AppsV1().DaemonSets("kube-system").Get(context.TODO(), "kured", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, ok := current.Annotations[ResetAnnotation]; ok {
+		t.Errorf("Expected reset annotation to be removed")
+	}
+}
@@ -0,0 +1,173 @@
+package rebootrequest
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Resource identifies the NodeRebootRequest custom resource.
+var Resource = schema.GroupVersionResource{Group: "kured.weave.works", Version: "v1alpha1", Resource: "noderebootrequests"}
+
+// Phase is the progress of the reboot of a node.
+type Phase string
+
+// The phases of a reboot, in the order they are normally passed through.
+// Completed and Failed are final.
+const (
+	// Pending means the reboot waits for its time, the reboot window or the
+	// lock.
+	Pending Phase = "Pending"
+	// Blocked means a blocker prevents the reboot.
+	Blocked Phase = "Blocked"
+	// Draining means the node is being drained.
+	Draining Phase = "Draining"
+	// Rebooting means the reboot has been commanded.
+	Rebooting Phase = "Rebooting"
+	// Completed means the node is back after the reboot.
+	Completed Phase = "Completed"
+	// Failed means the reboot could not be carried out.
+	Failed Phase = "Failed"
+)
+
+// progress orders the phases by how far a reboot has advanced.
+var progress = map[Phase]int{Pending: 0, Blocked: 1, Draining: 2, Rebooting: 3, Completed: 4}
+
+// Final determines whether a reboot in this phase has ended.
+func (p Phase) Final() bool {
+	return p == Completed || p == Failed
+}
+
+// NodeRebootRequest asks for the reboot of a node, or of all nodes matching
+// a selector.
+type NodeRebootRequest struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   Spec   `json:"spec,omitempty"`
+	Status Status `json:"status,omitempty"`
+}
+
+// Spec describes which nodes to reboot, when and why.
+type Spec struct {
+	// NodeName is the node to reboot.
+	NodeName string `json:"nodeName,omitempty"`
+	// NodeSelector selects the nodes to reboot, if NodeName is not given.
+	NodeSelector *metav1.LabelSelector `json:"nodeSelector,omitempty"`
+	// NotBefore is the earliest time to reboot; as soon as possible if nil.
+	NotBefore *metav1.Time `json:"notBefore,omitempty"`
+	// Reason is why the reboot was requested, for the record.
+	Reason string `json:"reason,omitempty"`
+}
+
+// Status tracks the reboots of the requested nodes.
+type Status struct {
+	// Phase summarizes the phases of the nodes.
+	Phase   Phase  `json:"phase,omitempty"`
+	Message string `json:"message,omitempty"`
+	// TargetNodes are the nodes matching NodeSelector when the request was
+	// first seen; nodes joining the cluster later are not rebooted.
+	TargetNodes []string `json:"targetNodes,omitempty"`
+	// Nodes are the statuses of the individual nodes, by name.
+	Nodes map[string]NodeStatus `json:"nodes,omitempty"`
+}
+
+// NodeStatus tracks the reboot of a node.
+type NodeStatus struct {
+	Phase   Phase  `json:"phase"`
+	Message string `json:"message,omitempty"`
+	// BootID is the boot ID of the node when the reboot was commanded, which
+	// tells whether it has rebooted since.
+	BootID             string      `json:"bootID,omitempty"`
+	LastTransitionTime metav1.Time `json:"lastTransitionTime"`
+}
+
+// Targets determines whether the request applies to the node. Once the
+// targets of a request using a selector have been recorded, only those are
+// targeted.
+func (r *NodeRebootRequest) Targets(node *v1.Node) (bool, error) {
+	if r.Spec.NodeName != "" {
+		return r.Spec.NodeName == node.Name, nil
+	}
+	if r.Spec.NodeSelector == nil {
+		return false, nil
+	}
+	if r.Status.TargetNodes != nil {
+		for _, name := range r.Status.TargetNodes {
+			if name == node.Name {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(r.Spec.NodeSelector)
+	if err != nil {
+		return false, fmt.Errorf("Invalid node selector: %v", err)
+	}
+	return selector.Matches(labels.Set(node.Labels)), nil
+}
+
+// NeedsTargets determines whether the targets of the request have yet to be
+// recorded.
+func (r *NodeRebootRequest) NeedsTargets() bool {
+	return r.Spec.NodeName == "" && r.Spec.NodeSelector != nil && r.Status.TargetNodes == nil && r.Status.Phase == ""
+}
+
+// Due determines whether the reboot may take place at t.
+func (r *NodeRebootRequest) Due(t time.Time) bool {
+	return r.Spec.NotBefore == nil || !t.Before(r.Spec.NotBefore.Time)
+}
+
+// Summarize returns the phase and message of a request for targets nodes,
+// given the statuses of those which have been seen. A request has failed
+// as soon as one node failed, and completed once all nodes completed;
+// otherwise it is in the most advanced phase of its nodes.
+func Summarize(nodes map[string]NodeStatus, targets int) (Phase, string) {
+	var phase Phase = Pending
+	message := ""
+	first := true
+	completed := 0
+	for _, name := range sortedNames(nodes) {
+		status := nodes[name]
+		switch status.Phase {
+		case Failed:
+			return Failed, describe(name, status)
+		case Completed:
+			completed++
+			continue
+		}
+		if first || progress[status.Phase] > progress[phase] {
+			phase, message = status.Phase, describe(name, status)
+			first = false
+		}
+	}
+	if targets > 0 && completed >= targets {
+		return Completed, ""
+	}
+	if first {
+		return Pending, ""
+	}
+	return phase, message
+}
+
+// describe prefixes the message of a node with its name.
+func describe(name string, status NodeStatus) string {
+	if status.Message == "" {
+		return name
+	}
+	return fmt.Sprintf("%s: %s", name, status.Message)
+}
+
+func sortedNames(nodes map[string]NodeStatus) []string {
+	names := make([]string, 0, len(nodes))
+	for name := range nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,136 @@
+package rebootrequest
+
+import (
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestTargets(t *testing.T) {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a", Labels: map[string]string{"pool": "db"}}}
+
+	tests := []struct {
+		name    string
+		spec    Spec
+		targets bool
+		err     bool
+	}{
+		{"node name", Spec{NodeName: "node-a"}, true, false},
+		{"other node name", Spec{NodeName: "node-b"}, false, false},
+		{"name takes precedence", Spec{NodeName: "node-b", NodeSelector: &metav1.LabelSelector{}}, false, false},
+		{"matching selector", Spec{NodeSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"pool": "db"}}}, true, false},
+		{"other selector", Spec{NodeSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"pool": "web"}}}, false, false},
+		{"empty selector", Spec{NodeSelector: &metav1.LabelSelector{}}, true, false},
+		{"no target", Spec{}, false, false},
+		{"invalid selector", Spec{NodeSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "pool", Operator: "Near"}}}}, false, true},
+	}
+
+	for _, tst := range tests {
+		r := &NodeRebootRequest{Spec: tst.spec}
+		targets, err := r.Targets(node)
+		if (err != nil) != tst.err {
+			t.Errorf("Test %s: Expected error %v got %v", tst.name, tst.err, err)
+		}
+		if targets != tst.targets {
+			t.Errorf("Test %s: Expected %v got %v", tst.name, tst.targets, targets)
+		}
+	}
+
+	// Once recorded, only the targets seen first are rebooted
+	selector := &metav1.LabelSelector{MatchLabels: map[string]string{"pool": "db"}}
+	recorded := []struct {
+		name    string
+		targets []string
+		result  bool
+	}{
+		{"recorded target", []string{"node-0", "node-a"}, true},
+		{"joined later", []string{"node-0", "node-b"}, false},
+	}
+	for _, tst := range recorded {
+		r := &NodeRebootRequest{Spec: Spec{NodeSelector: selector}, Status: Status{TargetNodes: tst.targets}}
+		if targets, err := r.Targets(node); err != nil || targets != tst.result {
+			t.Errorf("Test %s: Expected %v got %v %v", tst.name, tst.result, targets, err)
+		}
+	}
+}
+
+func TestNeedsTargets(t *testing.T) {
+	selector := &metav1.LabelSelector{}
+	tests := []struct {
+		name    string
+		request NodeRebootRequest
+		needs   bool
+	}{
+		{"new selector", NodeRebootRequest{Spec: Spec{NodeSelector: selector}}, true},
+		{"recorded", NodeRebootRequest{Spec: Spec{NodeSelector: selector}, Status: Status{TargetNodes: []string{"node-a"}}}, false},
+		{"no match", NodeRebootRequest{Spec: Spec{NodeSelector: selector}, Status: Status{Phase: Completed}}, false},
+		{"node name", NodeRebootRequest{Spec: Spec{NodeName: "node-a"}}, false},
+	}
+
+	for _, tst := range tests {
+		if needs := tst.request.NeedsTargets(); needs != tst.needs {
+			t.Errorf("Test %s: Expected %v got %v", tst.name, tst.needs, needs)
+		}
+	}
+}
+
+func TestDue(t *testing.T) {
+	notBefore := metav1.NewTime(time.Date(2021, 3, 1, 2, 0, 0, 0, time.UTC))
+
+	tests := []struct {
+		name      string
+		notBefore *metav1.Time
+		t         time.Time
+		due       bool
+	}{
+		{"no time", nil, time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC), true},
+		{"before", &notBefore, time.Date(2021, 3, 1, 1, 59, 59, 0, time.UTC), false},
+		{"at", &notBefore, time.Date(2021, 3, 1, 2, 0, 0, 0, time.UTC), true},
+		{"after", &notBefore, time.Date(2021, 3, 2, 0, 0, 0, 0, time.UTC), true},
+	}
+
+	for _, tst := range tests {
+		r := &NodeRebootRequest{Spec: Spec{NotBefore: tst.notBefore}}
+		if due := r.Due(tst.t); due != tst.due {
+			t.Errorf("Test %s: Expected %v got %v", tst.name, tst.due, due)
+		}
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	tests := []struct {
+		name    string
+		nodes   map[string]NodeStatus
+		targets int
+		phase   Phase
+		message string
+	}{
+		{"nothing seen", nil, 2, Pending, ""},
+		{"single pending", map[string]NodeStatus{"node-a": {Phase: Pending, Message: "outside of reboot window"}}, 1, Pending, "node-a: outside of reboot window"},
+		{"most advanced", map[string]NodeStatus{
+			"node-a": {Phase: Blocked, Message: "blocked by prometheus"},
+			"node-b": {Phase: Draining},
+			"node-c": {Phase: Pending},
+		}, 3, Draining, "node-b"},
+		{"partly completed", map[string]NodeStatus{
+			"node-a": {Phase: Completed},
+			"node-b": {Phase: Pending, Message: "waiting for lock"},
+		}, 2, Pending, "node-b: waiting for lock"},
+		{"completed nodes not yet all seen", map[string]NodeStatus{"node-a": {Phase: Completed}}, 2, Pending, ""},
+		{"all completed", map[string]NodeStatus{"node-a": {Phase: Completed}, "node-b": {Phase: Completed}}, 2, Completed, ""},
+		{"failed", map[string]NodeStatus{
+			"node-a": {Phase: Completed},
+			"node-b": {Phase: Failed, Message: "drain failed"},
+			"node-c": {Phase: Rebooting},
+		}, 3, Failed, "node-b: drain failed"},
+	}
+
+	for _, tst := range tests {
+		phase, message := Summarize(tst.nodes, tst.targets)
+		if phase != tst.phase || message != tst.message {
+			t.Errorf("Test %s: Expected %s %q got %s %q", tst.name, tst.phase, tst.message, phase, message)
+		}
+	}
+}
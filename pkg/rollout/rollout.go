@@ -0,0 +1,122 @@
+package rollout
+
+import (
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/weaveworks/kured/pkg/controller"
+)
+
+// Wave is a group of nodes rebooted before those of the following waves.
+type Wave struct {
+	Name     string
+	Selector labels.Selector
+	// Soak is how long to wait after the last reboot in the wave before
+	// rebooting the nodes of the following waves.
+	Soak time.Duration
+}
+
+// Plan divides the nodes into waves. Each node belongs to the first wave
+// whose selector matches it; nodes matching none form a final wave.
+type Plan struct {
+	Waves []Wave
+}
+
+// NewWave creates a wave of the nodes matching selector, given in the
+// format of kubectl's --selector.
+func NewWave(name, selector string, soak time.Duration) (Wave, error) {
+	parsed, err := labels.Parse(selector)
+	if err != nil {
+		return Wave{}, fmt.Errorf("Invalid selector of wave %s: %v", name, err)
+	}
+	return Wave{Name: name, Selector: parsed, Soak: soak}, nil
+}
+
+// WaveOf returns the index of the wave of a node, which is len(p.Waves) for
+// the final wave.
+func (p *Plan) WaveOf(node *v1.Node) int {
+	for i, wave := range p.Waves {
+		if wave.Selector.Matches(labels.Set(node.Labels)) {
+			return i
+		}
+	}
+	return len(p.Waves)
+}
+
+// waveName returns the name of the wave with index i.
+func (p *Plan) waveName(i int) string {
+	if i < len(p.Waves) {
+		return p.Waves[i].Name
+	}
+	return "rest"
+}
+
+// soak returns the soak time of the wave with index i.
+func (p *Plan) soak(i int) time.Duration {
+	if i < len(p.Waves) {
+		return p.Waves[i].Soak
+	}
+	return 0
+}
+
+// Check determines whether the named node may be rebooted at now, given the
+// state of all nodes. The rollout halts while any node is marked as failed
+// after its reboot; otherwise a node waits until no node of an earlier wave
+// requires a reboot, all of them are ready, and the soak time has passed
+// since their last reboot.
+func (p *Plan) Check(name string, nodes []v1.Node, now time.Time) (bool, string) {
+	var node *v1.Node
+	for i := range nodes {
+		if nodes[i].Name == name {
+			node = &nodes[i]
+		}
+	}
+	if node == nil {
+		return true, fmt.Sprintf("node %s not found", name)
+	}
+	wave := p.WaveOf(node)
+
+	for i := range nodes {
+		other := &nodes[i]
+		if reason, failed := other.Annotations[controller.RebootFailedAnnotation]; failed {
+			return true, fmt.Sprintf("rollout halted, node %s failed after its reboot: %s", other.Name, reason)
+		}
+	}
+
+	for i := range nodes {
+		other := &nodes[i]
+		otherWave := p.WaveOf(other)
+		if otherWave >= wave {
+			continue
+		}
+		if _, required := other.Annotations[controller.RebootRequiredAnnotation]; required {
+			return true, fmt.Sprintf("waiting for node %s of wave %s to reboot", other.Name, p.waveName(otherWave))
+		}
+		if !ready(other) {
+			return true, fmt.Sprintf("waiting for node %s of wave %s to become ready", other.Name, p.waveName(otherWave))
+		}
+		if value, ok := other.Annotations[controller.LastRebootAnnotation]; ok {
+			rebooted, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return true, fmt.Sprintf("invalid %s annotation on node %s: %v", controller.LastRebootAnnotation, other.Name, err)
+			}
+			if soaked := rebooted.Add(p.soak(otherWave)); now.Before(soaked) {
+				return true, fmt.Sprintf("wave %s soaking until %v", p.waveName(otherWave), soaked)
+			}
+		}
+	}
+
+	return false, ""
+}
+
+func ready(node *v1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == v1.NodeReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -0,0 +1,165 @@
+package rollout
+
+import (
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/weaveworks/kured/pkg/controller"
+)
+
+func testNode(name, wave string, ready bool, annotations map[string]string) v1.Node {
+	status := v1.ConditionFalse
+	if ready {
+		status = v1.ConditionTrue
+	}
+	return v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"wave": wave}, Annotations: annotations},
+		Status:     v1.NodeStatus{Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: status}}},
+	}
+}
+
+func testPlan(t *testing.T) *Plan {
+	canary, err := NewWave("canary", "wave=canary", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	early, err := NewWave("early", "wave in (early,first)", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Plan{Waves: []Wave{canary, early}}
+}
+
+func TestNewWave(t *testing.T) {
+	if _, err := NewWave("bad", "wave in canary", 0); err == nil {
+		t.Errorf("Expected error for invalid selector")
+	}
+}
+
+func TestWaveOf(t *testing.T) {
+	plan := testPlan(t)
+	tests := []struct {
+		wave     string
+		expected int
+	}{
+		{"canary", 0},
+		{"early", 1},
+		{"first", 1},
+		{"other", 2},
+	}
+	for _, tst := range tests {
+		node := testNode("node", tst.wave, true, nil)
+		if wave := plan.WaveOf(&node); wave != tst.expected {
+			t.Errorf("Test %s: Expected %d got %d", tst.wave, tst.expected, wave)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	plan := testPlan(t)
+	now := time.Date(2021, 3, 2, 12, 0, 0, 0, time.UTC)
+	required := map[string]string{controller.RebootRequiredAnnotation: "2021-03-01T00:00:00Z"}
+	rebooted := func(t time.Time) map[string]string {
+		return map[string]string{controller.LastRebootAnnotation: t.Format(time.RFC3339)}
+	}
+
+	tests := []struct {
+		name    string
+		node    string
+		nodes   []v1.Node
+		blocked bool
+		reason  string
+	}{
+		{
+			name: "canary goes first",
+			node: "canary-1",
+			nodes: []v1.Node{
+				testNode("canary-1", "canary", true, required),
+				testNode("early-1", "early", true, required),
+			},
+		},
+		{
+			name: "waiting for canary",
+			node: "early-1",
+			nodes: []v1.Node{
+				testNode("canary-1", "canary", true, required),
+				testNode("early-1", "early", true, required),
+			},
+			blocked: true,
+			reason:  "waiting for node canary-1 of wave canary to reboot",
+		},
+		{
+			name: "canary soaking",
+			node: "early-1",
+			nodes: []v1.Node{
+				testNode("canary-1", "canary", true, rebooted(now.Add(-23*time.Hour))),
+				testNode("early-1", "early", true, required),
+			},
+			blocked: true,
+			reason:  "wave canary soaking until 2021-03-02 13:00:00 +0000 UTC",
+		},
+		{
+			name: "canary soaked",
+			node: "early-1",
+			nodes: []v1.Node{
+				testNode("canary-1", "canary", true, rebooted(now.Add(-25*time.Hour))),
+				testNode("early-1", "early", true, required),
+			},
+		},
+		{
+			name: "canary not ready",
+			node: "early-1",
+			nodes: []v1.Node{
+				testNode("canary-1", "canary", false, rebooted(now.Add(-25*time.Hour))),
+				testNode("early-1", "early", true, required),
+			},
+			blocked: true,
+			reason:  "waiting for node canary-1 of wave canary to become ready",
+		},
+		{
+			name: "rest waits for all waves",
+			node: "other-1",
+			nodes: []v1.Node{
+				testNode("canary-1", "canary", true, rebooted(now.Add(-25*time.Hour))),
+				testNode("early-1", "early", true, rebooted(now.Add(-30*time.Minute))),
+				testNode("other-1", "other", true, required),
+			},
+			blocked: true,
+			reason:  "wave early soaking until 2021-03-02 12:30:00 +0000 UTC",
+		},
+		{
+			name: "same wave does not wait",
+			node: "early-2",
+			nodes: []v1.Node{
+				testNode("early-1", "early", true, required),
+				testNode("early-2", "first", true, required),
+			},
+		},
+		{
+			name: "halted",
+			node: "early-2",
+			nodes: []v1.Node{
+				testNode("early-1", "early", false, map[string]string{controller.RebootFailedAnnotation: "node not ready"}),
+				testNode("early-2", "early", true, required),
+			},
+			blocked: true,
+			reason:  "rollout halted, node early-1 failed after its reboot: node not ready",
+		},
+		{
+			name:    "unknown node",
+			node:    "gone",
+			blocked: true,
+			reason:  "node gone not found",
+		},
+	}
+
+	for _, tst := range tests {
+		blocked, reason := plan.Check(tst.node, tst.nodes, now)
+		if blocked != tst.blocked || reason != tst.reason {
+			t.Errorf("Test %s: Expected %v %q got %v %q", tst.name, tst.blocked, tst.reason, blocked, reason)
+		}
+	}
+}
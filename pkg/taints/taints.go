@@ -22,8 +22,15 @@ type Taint struct {
 }
 
 // New provides a new taint.
-func New(client *kubernetes.Clientset, nodeID, taintName string, effect v1.TaintEffect) *Taint {
-	exists, _, _ := taintExists(client, nodeID, taintName)
+func New(ctx context.Context, client *kubernetes.Clientset, nodeID, taintName string, effect v1.TaintEffect) (*Taint, error) {
+	exists := false
+	if taintName != "" {
+		var err error
+		exists, _, _, err = taintExists(ctx, client, nodeID, taintName)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	return &Taint{
 		client:    client,
@@ -31,65 +38,74 @@ func New(client *kubernetes.Clientset, nodeID, taintName string, effect v1.Taint
 		taintName: taintName,
 		effect:    effect,
 		exists:    exists,
-	}
+	}, nil
 }
 
 // Enable creates the taint for a node. Creating an existing taint is a noop.
-func (t *Taint) Enable() {
+func (t *Taint) Enable(ctx context.Context) error {
 	if t.taintName == "" {
-		return
+		return nil
 	}
 
 	if t.exists {
-		return
+		return nil
 	}
 
-	preferNoSchedule(t.client, t.nodeID, t.taintName, t.effect, true)
+	if err := preferNoSchedule(ctx, t.client, t.nodeID, t.taintName, t.effect, true); err != nil {
+		return err
+	}
 
 	t.exists = true
+	return nil
 }
 
 // Disable removes the taint for a node. Removing a missing taint is a noop.
-func (t *Taint) Disable() {
+func (t *Taint) Disable(ctx context.Context) error {
 	if t.taintName == "" {
-		return
+		return nil
 	}
 
 	if !t.exists {
-		return
+		return nil
 	}
 
-	preferNoSchedule(t.client, t.nodeID, t.taintName, t.effect, false)
+	if err := preferNoSchedule(ctx, t.client, t.nodeID, t.taintName, t.effect, false); err != nil {
+		return err
+	}
 
 	t.exists = false
+	return nil
 }
 
-func taintExists(client *kubernetes.Clientset, nodeID, taintName string) (bool, int, *v1.Node) {
-	updatedNode, err := client.CoreV1().Nodes().Get(context.TODO(), nodeID, metav1.GetOptions{})
-	if err != nil || updatedNode == nil {
-		log.Fatalf("Error reading node %s: %v", nodeID, err)
+func taintExists(ctx context.Context, client *kubernetes.Clientset, nodeID, taintName string) (bool, int, *v1.Node, error) {
+	updatedNode, err := client.CoreV1().Nodes().Get(ctx, nodeID, metav1.GetOptions{})
+	if err != nil {
+		return false, 0, nil, err
 	}
 
 	for i, taint := range updatedNode.Spec.Taints {
 		if taint.Key == taintName {
-			return true, i, updatedNode
+			return true, i, updatedNode, nil
 		}
 	}
 
-	return false, 0, updatedNode
+	return false, 0, updatedNode, nil
 }
 
-func preferNoSchedule(client *kubernetes.Clientset, nodeID, taintName string, effect v1.TaintEffect, shouldExists bool) {
-	taintExists, offset, updatedNode := taintExists(client, nodeID, taintName)
+func preferNoSchedule(ctx context.Context, client *kubernetes.Clientset, nodeID, taintName string, effect v1.TaintEffect, shouldExists bool) error {
+	taintExists, offset, updatedNode, err := taintExists(ctx, client, nodeID, taintName)
+	if err != nil {
+		return err
+	}
 
 	if taintExists && shouldExists {
 		log.Debugf("Taint %v exists already for node %v.", taintName, nodeID)
-		return
+		return nil
 	}
 
 	if !taintExists && !shouldExists {
 		log.Debugf("Taint %v already missing for node %v.", taintName, nodeID)
-		return
+		return nil
 	}
 
 	type patchTaints struct {
@@ -150,12 +166,12 @@ func preferNoSchedule(client *kubernetes.Clientset, nodeID, taintName string, ef
 
 	patchBytes, err := json.Marshal(patches)
 	if err != nil {
-		log.Fatalf("Error encoding taint patch for node %s: %v", nodeID, err)
+		return err
 	}
 
-	_, err = client.CoreV1().Nodes().Patch(context.TODO(), nodeID, types.JSONPatchType, patchBytes, metav1.PatchOptions{})
+	_, err = client.CoreV1().Nodes().Patch(ctx, nodeID, types.JSONPatchType, patchBytes, metav1.PatchOptions{})
 	if err != nil {
-		log.Fatalf("Error patching taint for node %s: %v", nodeID, err)
+		return err
 	}
 
 	if shouldExists {
@@ -163,4 +179,5 @@ func preferNoSchedule(client *kubernetes.Clientset, nodeID, taintName string, ef
 	} else {
 		log.Info("Node taint removed")
 	}
+	return nil
 }
@@ -0,0 +1,210 @@
+package timewindow
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+// Blackout is a period of time during which no reboots may happen, regardless
+// of the reboot windows.
+type Blackout struct {
+	// Start is the beginning of the blackout.
+	Start time.Time
+	// End is the first instant after the blackout.
+	End time.Time
+	// Summary optionally describes the blackout, e.g. the name of a holiday.
+	Summary string
+}
+
+// Contains determines whether the specified time is within this blackout.
+func (b Blackout) Contains(t time.Time) bool {
+	return !t.Before(b.Start) && t.Before(b.End)
+}
+
+// String returns a string representation of this blackout.
+func (b Blackout) String() string {
+	var s string
+	if isMidnight(b.Start) && isMidnight(b.End) {
+		last := b.End.AddDate(0, 0, -1)
+		s = b.Start.Format(dateLayout)
+		if last.After(b.Start) {
+			s += ".." + last.Format(dateLayout)
+		}
+		s += " " + b.Start.Location().String()
+	} else {
+		s = fmt.Sprintf("%s..%s", b.Start.Format(time.RFC3339), b.End.Format(time.RFC3339))
+	}
+	if b.Summary != "" {
+		s += fmt.Sprintf(" (%s)", b.Summary)
+	}
+	return s
+}
+
+// ParseBlackout creates a Blackout from a date of the form "YYYY-MM-DD" or an
+// inclusive range of dates "YYYY-MM-DD..YYYY-MM-DD". The dates are whole days
+// in location.
+func ParseBlackout(spec, location string) (Blackout, error) {
+	loc, err := time.LoadLocation(location)
+	if err != nil {
+		return Blackout{}, err
+	}
+
+	first, last := spec, spec
+	if i := strings.Index(spec, ".."); i >= 0 {
+		first, last = spec[:i], spec[i+2:]
+	}
+
+	start, err := time.ParseInLocation(dateLayout, strings.TrimSpace(first), loc)
+	if err != nil {
+		return Blackout{}, fmt.Errorf("Invalid blackout date %q, expected YYYY-MM-DD[..YYYY-MM-DD]", spec)
+	}
+	end, err := time.ParseInLocation(dateLayout, strings.TrimSpace(last), loc)
+	if err != nil {
+		return Blackout{}, fmt.Errorf("Invalid blackout date %q, expected YYYY-MM-DD[..YYYY-MM-DD]", spec)
+	}
+	if end.Before(start) {
+		return Blackout{}, fmt.Errorf("Invalid blackout date range %q, end is before start", spec)
+	}
+
+	return Blackout{Start: start, End: end.AddDate(0, 0, 1)}, nil
+}
+
+// ParseICalendar reads the events of an iCalendar (RFC 5545) stream as
+// blackouts. Only DTSTART, DTEND and SUMMARY are taken into account;
+// recurring events are not supported and rejected, rather than blacking out
+// their first occurrence only. Dates and floating times are interpreted in
+// location.
+func ParseICalendar(r io.Reader, location string) ([]Blackout, error) {
+	loc, err := time.LoadLocation(location)
+	if err != nil {
+		return nil, err
+	}
+
+	lines, err := unfoldICalendar(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var blackouts []Blackout
+	var event *Blackout
+	var allDay bool
+	var recurrence string
+	for _, line := range lines {
+		name, params, value := splitICalendarLine(line)
+
+		switch {
+		case name == "BEGIN" && value == "VEVENT":
+			event, allDay, recurrence = &Blackout{}, false, ""
+		case event == nil:
+		case name == "END" && value == "VEVENT":
+			if event.Start.IsZero() {
+				return nil, fmt.Errorf("Invalid calendar event without DTSTART: %s", event.Summary)
+			}
+			if recurrence != "" {
+				return nil, fmt.Errorf("Unsupported recurring calendar event %q (%s), list its occurrences as single events instead", event.Summary, recurrence)
+			}
+			if event.End.IsZero() {
+				// Without an end, an all-day event lasts one day and
+				// any other event ends when it starts.
+				event.End = event.Start
+				if allDay {
+					event.End = event.Start.AddDate(0, 0, 1)
+				}
+			}
+			blackouts = append(blackouts, *event)
+			event = nil
+		case name == "DTSTART":
+			if event.Start, allDay, err = parseICalendarTime(params, value, loc); err != nil {
+				return nil, err
+			}
+		case name == "DTEND":
+			if event.End, _, err = parseICalendarTime(params, value, loc); err != nil {
+				return nil, err
+			}
+		case name == "RRULE" || name == "RDATE" || name == "EXDATE":
+			recurrence = name
+		case name == "SUMMARY":
+			event.Summary = strings.NewReplacer(`\,`, ",", `\;`, ";", `\n`, " ", `\\`, `\`).Replace(value)
+		}
+	}
+
+	return blackouts, nil
+}
+
+// unfoldICalendar reads the content lines of an iCalendar stream, joining
+// lines which have been folded onto continuation lines.
+func unfoldICalendar(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// splitICalendarLine splits a content line into its upper-cased name, its
+// parameters and its value.
+func splitICalendarLine(line string) (string, map[string]string, string) {
+	params := map[string]string{}
+
+	i := strings.Index(line, ":")
+	if i < 0 {
+		return strings.ToUpper(line), params, ""
+	}
+	value := line[i+1:]
+
+	parts := strings.Split(line[:i], ";")
+	for _, param := range parts[1:] {
+		if j := strings.Index(param, "="); j >= 0 {
+			params[strings.ToUpper(param[:j])] = strings.Trim(param[j+1:], `"`)
+		}
+	}
+
+	return strings.ToUpper(parts[0]), params, value
+}
+
+// parseICalendarTime parses a DATE or DATE-TIME value, returning whether it
+// was a DATE.
+func parseICalendarTime(params map[string]string, value string, loc *time.Location) (time.Time, bool, error) {
+	if tzid, ok := params["TZID"]; ok {
+		var err error
+		if loc, err = time.LoadLocation(tzid); err != nil {
+			return time.Time{}, false, err
+		}
+	}
+
+	if params["VALUE"] == "DATE" || len(value) == len("20060102") {
+		t, err := time.ParseInLocation("20060102", value, loc)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("Invalid calendar date: %s", value)
+		}
+		return t, true, nil
+	}
+
+	if strings.HasSuffix(value, "Z") {
+		loc = time.UTC
+		value = strings.TrimSuffix(value, "Z")
+	}
+	t, err := time.ParseInLocation("20060102T150405", value, loc)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("Invalid calendar date-time: %s", value)
+	}
+	return t, false, nil
+}
+
+// isMidnight determines whether t is the beginning of a day in its location.
+func isMidnight(t time.Time) bool {
+	return t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 && t.Nanosecond() == 0
+}
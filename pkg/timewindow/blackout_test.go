@@ -0,0 +1,131 @@
+package timewindow
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseBlackout(t *testing.T) {
+	tests := []struct {
+		spec   string
+		result string
+	}{
+		{"2019-11-29", "2019-11-29 UTC"},
+		{"2019-12-24..2019-12-26", "2019-12-24..2019-12-26 UTC"},
+		{"2019-12-31..2019-12-31", "2019-12-31 UTC"},
+	}
+
+	for _, tst := range tests {
+		b, err := ParseBlackout(tst.spec, "UTC")
+		if err != nil {
+			t.Errorf("Received error for input %s: %v", tst.spec, err)
+		} else if b.String() != tst.result {
+			t.Errorf("Test %s: Expected %s got %s", tst.spec, tst.result, b.String())
+		}
+	}
+
+	for _, tst := range []string{"", "2019-13-01", "29.11.2019", "2019-12-26..2019-12-24", "2019-12-24..", "2019-12-24..tomorrow"} {
+		if _, err := ParseBlackout(tst, "UTC"); err == nil {
+			t.Errorf("Expected to receive error for input %s", tst)
+		}
+	}
+}
+
+const testCalendar = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"PRODID:-//kured//test//EN\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART;VALUE=DATE:20191129\r\n" +
+	"SUMMARY:Black Friday\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART;VALUE=DATE:20191224\r\n" +
+	"DTEND;VALUE=DATE:20191227\r\n" +
+	"SUMMARY:Christmas\\, Boxing Day\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART;TZID=America/New_York:20191203T090000\r\n" +
+	"DTEND;TZID=America/New_York:20191203T170000\r\n" +
+	"SUMMARY:Release of a very long product name which has to be folded onto \r\n" +
+	" a second line\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART:20191210T020000Z\r\n" +
+	"DTEND:20191210T040000Z\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParseICalendar(t *testing.T) {
+	blackouts, err := ParseICalendar(strings.NewReader(testCalendar), "Europe/Berlin")
+	if err != nil {
+		t.Fatalf("Failed to parse calendar: %v", err)
+	}
+
+	expected := []string{
+		"2019-11-29 Europe/Berlin (Black Friday)",
+		"2019-12-24..2019-12-26 Europe/Berlin (Christmas, Boxing Day)",
+		"2019-12-03T09:00:00-05:00..2019-12-03T17:00:00-05:00 (Release of a very long product name which has to be folded onto a second line)",
+		"2019-12-10T02:00:00Z..2019-12-10T04:00:00Z",
+	}
+	if len(blackouts) != len(expected) {
+		t.Fatalf("Expected %d blackouts got %d: %v", len(expected), len(blackouts), blackouts)
+	}
+	for i, b := range blackouts {
+		if b.String() != expected[i] {
+			t.Errorf("Test %d: Expected %s got %s", i, expected[i], b.String())
+		}
+	}
+
+	for _, tst := range []string{
+		"BEGIN:VEVENT\nSUMMARY:no start\nEND:VEVENT\n",
+		"BEGIN:VEVENT\nDTSTART:2019-12-10\nEND:VEVENT\n",
+		"BEGIN:VEVENT\nDTSTART;TZID=Mars/Olympus_Mons:20191210T020000\nEND:VEVENT\n",
+		"BEGIN:VEVENT\nDTSTART;VALUE=DATE:20191225\nRRULE:FREQ=YEARLY\nSUMMARY:Christmas\nEND:VEVENT\n",
+		"BEGIN:VEVENT\nDTSTART;VALUE=DATE:20191225\nRDATE;VALUE=DATE:20201225\nEND:VEVENT\n",
+	} {
+		if _, err := ParseICalendar(strings.NewReader(tst), "UTC"); err == nil {
+			t.Errorf("Expected to receive error for input %q", tst)
+		}
+	}
+}
+
+func TestScheduleBlackouts(t *testing.T) {
+	weekdays, err := Parse("mo,tu,we,th,fr 02:00 05:00 Europe/Berlin", "UTC")
+	if err != nil {
+		t.Fatalf("Failed to parse window: %v", err)
+	}
+	blackouts, err := ParseICalendar(strings.NewReader(testCalendar), "Europe/Berlin")
+	if err != nil {
+		t.Fatalf("Failed to parse calendar: %v", err)
+	}
+	schedule := NewSchedule(weekdays)
+	schedule.Exclude(blackouts...)
+
+	tests := []struct {
+		time   string
+		result bool
+	}{
+		{"2019/11/28 02:00 UTC", true},  // Thu 03:00 CET
+		{"2019/11/29 02:00 UTC", false}, // Black Friday
+		{"2019/11/28 23:30 UTC", false}, // Black Friday 00:30 CET, outside window anyway
+		{"2019/12/25 02:00 UTC", false}, // Christmas
+		{"2019/12/27 02:00 UTC", true},  // Fri after Boxing Day
+		{"2019/12/10 02:30 UTC", false}, // Tue, blacked out 02:00-04:00 UTC
+		{"2019/12/10 01:30 UTC", true},  // Tue 02:30 CET
+	}
+
+	for _, tst := range tests {
+		tm, err := time.Parse("2006/01/02 15:04 MST", tst.time)
+		if err != nil {
+			t.Errorf("Failed to parse time \"%s\": %v", tst.time, err)
+		} else if tst.result != schedule.Contains(tm) {
+			t.Errorf("(%s) contains (%s) didn't match expected result of %v", schedule.String(), tst.time, tst.result)
+		}
+	}
+
+	expected := "---MonTueWedThuFri--- between 02:00 and 05:00 Europe/Berlin (except during 4 blackouts)"
+	if schedule.String() != expected {
+		t.Errorf("Expected %s got %s", expected, schedule.String())
+	}
+}
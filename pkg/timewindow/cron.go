@@ -0,0 +1,254 @@
+package timewindow
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// monthStrings maps month abbreviations to months.
+var monthStrings = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+// cronSchedule holds the parsed fields of a cron expression as bit sets.
+type cronSchedule struct {
+	minutes uint64
+	hours   uint64
+	doms    uint64
+	months  uint64
+	dows    uint64
+	// nthDows holds bit n*7+weekday for "weekday#n" entries, n in 1..5.
+	nthDows uint64
+	// domStar and dowStar record whether day-of-month and day-of-week were
+	// unrestricted, which selects between the cron "and" and "or" semantics.
+	domStar bool
+	dowStar bool
+}
+
+// CronWindow is a window opening at each activation of a cron expression and
+// staying open for a fixed duration.
+type CronWindow struct {
+	spec     string
+	schedule cronSchedule
+	duration time.Duration
+	location *time.Location
+}
+
+// NewCron creates a CronWindow from a standard five field cron expression
+// ("minute hour day-of-month month day-of-week"). Besides lists, ranges,
+// steps and names, the day-of-week field accepts "weekday#n" for the n-th
+// such weekday of the month, e.g. "sun#1" for the first Sunday.
+func NewCron(spec string, duration time.Duration, location string) (*CronWindow, error) {
+	cw := &CronWindow{spec: strings.Join(strings.Fields(spec), " "), duration: duration}
+
+	if duration <= 0 {
+		return nil, fmt.Errorf("Invalid cron window duration: %v", duration)
+	}
+
+	var err error
+	if cw.location, err = time.LoadLocation(location); err != nil {
+		return nil, err
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("Invalid cron expression, expected 5 fields: %s", spec)
+	}
+
+	s := &cw.schedule
+	if s.minutes, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, err
+	}
+	if s.hours, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, err
+	}
+	if s.doms, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, err
+	}
+	if s.months, err = parseCronField(fields[3], 1, 12, monthStrings); err != nil {
+		return nil, err
+	}
+	if s.dows, s.nthDows, err = parseCronDows(fields[4]); err != nil {
+		return nil, err
+	}
+	s.domStar = strings.HasPrefix(fields[2], "*")
+	s.dowStar = strings.HasPrefix(fields[4], "*")
+
+	return cw, nil
+}
+
+// ParseCron creates a CronWindow from a specification of the form
+// "[CRON_TZ=TIMEZONE] MIN HOUR DOM MONTH DOW DURATION", e.g.
+// "CRON_TZ=Europe/Berlin 0 2 * * mon-fri 3h". location is used if no
+// CRON_TZ is given.
+func ParseCron(spec, location string) (*CronWindow, error) {
+	fields := strings.Fields(spec)
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "CRON_TZ=") {
+		location = strings.TrimPrefix(fields[0], "CRON_TZ=")
+		fields = fields[1:]
+	}
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("Invalid cron window, expected \"[CRON_TZ=TIMEZONE] MIN HOUR DOM MONTH DOW DURATION\": %s", spec)
+	}
+
+	duration, err := time.ParseDuration(fields[5])
+	if err != nil {
+		return nil, err
+	}
+
+	return NewCron(strings.Join(fields[:5], " "), duration, location)
+}
+
+// Contains determines whether the specified time is within this window, i.e.
+// whether the cron expression activated less than the duration before it.
+func (cw *CronWindow) Contains(t time.Time) bool {
+	loctime := t.In(cw.location)
+	earliest := loctime.Add(-cw.duration)
+
+	// Walk back minute by minute, skipping whole days and hours which
+	// cannot match.
+	candidate := loctime.Truncate(time.Minute)
+	for candidate.After(earliest) {
+		if !cw.schedule.matchesDay(candidate) {
+			candidate = time.Date(candidate.Year(), candidate.Month(), candidate.Day(), 0, 0, 0, 0, cw.location).Add(-time.Minute)
+			continue
+		}
+		if cw.schedule.hours&(1<<uint(candidate.Hour())) == 0 {
+			candidate = candidate.Add(-time.Duration(candidate.Minute()+1) * time.Minute)
+			continue
+		}
+		if cw.schedule.minutes&(1<<uint(candidate.Minute())) != 0 {
+			return true
+		}
+		candidate = candidate.Add(-time.Minute)
+	}
+
+	return false
+}
+
+// String returns a string representation of this time window.
+func (cw *CronWindow) String() string {
+	return fmt.Sprintf("cron %q for %v %s", cw.spec, cw.duration, cw.location.String())
+}
+
+// matchesDay determines whether the day of t matches the month, day-of-month
+// and day-of-week fields.
+func (s *cronSchedule) matchesDay(t time.Time) bool {
+	if s.months&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+
+	dom := s.doms&(1<<uint(t.Day())) != 0
+	nth := (t.Day()-1)/7 + 1
+	dow := s.dows&(1<<uint(t.Weekday())) != 0 || s.nthDows&(1<<uint(nth*7+int(t.Weekday()))) != 0
+
+	// As in cron, if both fields are restricted, either may match.
+	switch {
+	case s.domStar:
+		return dow
+	case s.dowStar:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// parseCronField parses a comma separated list of values, ranges and steps
+// within [min, max] into a bit set.
+func parseCronField(field string, min, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("Invalid step in cron field: %s", field)
+			}
+			part = part[:i]
+		}
+
+		low, high := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if low, err = parseCronValue(bounds[0], min, max, names); err != nil {
+				return 0, err
+			}
+			if high, err = parseCronValue(bounds[1], min, max, names); err != nil {
+				return 0, err
+			}
+			if low > high {
+				return 0, fmt.Errorf("Invalid range in cron field: %s", field)
+			}
+		default:
+			var err error
+			if low, err = parseCronValue(part, min, max, names); err != nil {
+				return 0, err
+			}
+			if step == 1 {
+				high = low
+			}
+		}
+
+		for v := low; v <= high; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+// parseCronValue parses a single number or name within [min, max].
+func parseCronValue(s string, min, max int, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < min || v > max {
+		return 0, fmt.Errorf("Invalid value in cron field: %s", s)
+	}
+	return v, nil
+}
+
+// parseCronDows parses the day-of-week field, which accepts 0-7 (both 0 and
+// 7 being Sunday), day names and "weekday#n" entries.
+func parseCronDows(field string) (uint64, uint64, error) {
+	names := make(map[string]int, len(dayStrings))
+	for name, day := range dayStrings {
+		names[name] = int(day)
+	}
+
+	var dows, nthDows uint64
+	for _, part := range strings.Split(field, ",") {
+		if i := strings.Index(part, "#"); i >= 0 {
+			day, err := parseCronValue(part[:i], 0, 7, names)
+			if err != nil {
+				return 0, 0, err
+			}
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 || n > 5 {
+				return 0, 0, fmt.Errorf("Invalid weekday occurrence in cron field: %s", part)
+			}
+			nthDows |= 1 << uint(n*7+day%7)
+			continue
+		}
+
+		bits, err := parseCronField(part, 0, 7, names)
+		if err != nil {
+			return 0, 0, err
+		}
+		dows |= bits
+	}
+
+	// Fold 7 onto Sunday
+	if dows&(1<<7) != 0 {
+		dows = dows&^(1<<7) | 1
+	}
+
+	return dows, nthDows, nil
+}
@@ -0,0 +1,112 @@
+package timewindow
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		spec   string
+		result string
+	}{
+		{"0 2 * * 1-5 3h", "cron \"0 2 * * 1-5\" for 3h0m0s UTC"},
+		{"CRON_TZ=Europe/Berlin  30 1  * * sun#1  90m", "cron \"30 1 * * sun#1\" for 1h30m0s Europe/Berlin"},
+		{"*/15 0-6/2 1,15 jan-jun mon,fri 1h", "cron \"*/15 0-6/2 1,15 jan-jun mon,fri\" for 1h0m0s UTC"},
+	}
+
+	for _, tst := range tests {
+		cw, err := ParseCron(tst.spec, "UTC")
+		if err != nil {
+			t.Errorf("Received error for input %s: %v", tst.spec, err)
+		} else if cw.String() != tst.result {
+			t.Errorf("Test %s: Expected %s got %s", tst.spec, tst.result, cw.String())
+		}
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"0 2 * * 1-5",
+		"0 2 * * 1-5 0s",
+		"0 2 * * 1-5 forever",
+		"60 2 * * * 1h",
+		"0 24 * * * 1h",
+		"0 2 0 * * 1h",
+		"0 2 * 13 * 1h",
+		"0 2 * * 8 1h",
+		"0 2 * * 5-1 1h",
+		"0 2 * * sun#6 1h",
+		"0 2 * * someday 1h",
+		"*/0 2 * * * 1h",
+		"CRON_TZ=Mars/Olympus_Mons 0 2 * * * 1h",
+	}
+
+	for _, tst := range tests {
+		if _, err := ParseCron(tst, "UTC"); err == nil {
+			t.Errorf("Expected to receive error for input %s", tst)
+		}
+	}
+}
+
+func TestCronContains(t *testing.T) {
+	type testcase struct {
+		time   string
+		result bool
+	}
+
+	tests := []struct {
+		spec  string
+		cases []testcase
+	}{
+		{"0 2 * * 1-5 3h", []testcase{
+			{"2019/04/04 01:59 UTC", false}, // Thu
+			{"2019/04/04 02:00 UTC", true},
+			{"2019/04/04 04:59 UTC", true},
+			{"2019/04/04 05:00 UTC", false},
+			{"2019/04/06 03:00 UTC", false}, // Sat
+			{"2019/04/08 03:00 UTC", true},  // Mon
+		}},
+		{"0 22 * * fri 8h", []testcase{
+			{"2019/04/05 21:00 UTC", false}, // Fri
+			{"2019/04/05 23:00 UTC", true},
+			{"2019/04/06 05:59 UTC", true}, // Sat, across midnight
+			{"2019/04/06 06:00 UTC", false},
+		}},
+		{"0 2 * * sun#1 4h", []testcase{
+			{"2019/04/07 03:00 UTC", true},  // first Sunday of April
+			{"2019/04/14 03:00 UTC", false}, // second Sunday
+			{"2019/05/05 05:30 UTC", true},  // first Sunday of May
+			{"2019/05/05 06:00 UTC", false},
+		}},
+		{"CRON_TZ=Europe/Berlin 0 2 1 * * 2h", []testcase{
+			{"2019/04/01 00:30 UTC", true},  // 02:30 CEST
+			{"2019/04/01 02:00 UTC", false}, // 04:00 CEST
+			{"2019/01/01 01:30 UTC", true},  // 02:30 CET
+			{"2019/04/02 00:30 UTC", false},
+		}},
+		{"0 3 13 * fri 1h", []testcase{
+			{"2019/09/13 03:30 UTC", true}, // Friday 13th
+			{"2019/09/20 03:30 UTC", true}, // any Friday
+			{"2019/10/13 03:30 UTC", true}, // any 13th
+			{"2019/10/14 03:30 UTC", false},
+		}},
+	}
+
+	for _, tst := range tests {
+		cw, err := ParseCron(tst.spec, "UTC")
+		if err != nil {
+			t.Fatalf("Failed to parse cron window %s: %v", tst.spec, err)
+		}
+
+		for _, c := range tst.cases {
+			tm, err := time.Parse("2006/01/02 15:04 MST", c.time)
+			if err != nil {
+				t.Errorf("Failed to parse time \"%s\": %v", c.time, err)
+			} else if c.result != cw.Contains(tm) {
+				t.Errorf("(%s) contains (%s) didn't match expected result of %v", cw.String(), c.time, c.result)
+			}
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package timewindow
+
+import (
+	"time"
+)
+
+// scanStep is the granularity at which windows are scanned for openings and
+// closings, which are then located to the second.
+const scanStep = time.Minute
+
+// Period is a span of time during which a window is open.
+type Period struct {
+	// Start is the time the window opens.
+	Start time.Time
+	// End is the time the window closes, or zero if it is still open at the
+	// horizon.
+	End time.Time
+}
+
+// Next returns up to n periods during which w is open, beginning with the
+// one containing t, looking at most horizon ahead. If w is open at t, the
+// first period starts at t. Since windows are scanned minute by minute,
+// gaps shorter than a minute may be missed.
+func Next(w Window, t time.Time, n int, horizon time.Duration) []Period {
+	limit := t.Add(horizon)
+
+	var periods []Period
+	for len(periods) < n {
+		start := t
+		if !w.Contains(t) {
+			var ok bool
+			if start, ok = nextChange(w, t, false, limit); !ok {
+				break
+			}
+		}
+
+		end, ok := nextChange(w, start, true, limit)
+		if !ok {
+			periods = append(periods, Period{Start: start})
+			break
+		}
+		periods = append(periods, Period{Start: start, End: end})
+		t = end
+	}
+
+	return periods
+}
+
+// Until returns how long it is from t until w opens, which is zero if w is
+// open at t. It returns false if w does not open within horizon.
+func Until(w Window, t time.Time, horizon time.Duration) (time.Duration, bool) {
+	if w.Contains(t) {
+		return 0, true
+	}
+	start, ok := nextChange(w, t, false, t.Add(horizon))
+	if !ok {
+		return 0, false
+	}
+	return start.Sub(t), true
+}
+
+// End returns the time at which the window containing t closes, looking at
+// most horizon ahead. It returns false if t is not within the window, or if
+// the window is still open at the horizon.
+func End(w Window, t time.Time, horizon time.Duration) (time.Time, bool) {
+	if !w.Contains(t) {
+		return time.Time{}, false
+	}
+	return nextChange(w, t, true, t.Add(horizon))
+}
+
+// nextChange returns the first time after t and no later than limit at which
+// w.Contains no longer returns contained.
+func nextChange(w Window, t time.Time, contained bool, limit time.Time) (time.Time, bool) {
+	last := t
+	for next := t.Truncate(scanStep).Add(scanStep); !next.After(limit); next = next.Add(scanStep) {
+		if w.Contains(next) != contained {
+			return bisect(w, last, next, contained), true
+		}
+		last = next
+	}
+	return time.Time{}, false
+}
+
+// bisect narrows down the change between before, where w.Contains returns
+// contained, and after, where it does not, to the second.
+func bisect(w Window, before, after time.Time, contained bool) time.Time {
+	for after.Sub(before) > time.Second {
+		middle := before.Add(after.Sub(before) / 2).Truncate(time.Second)
+		if !middle.After(before) {
+			break
+		}
+		if w.Contains(middle) == contained {
+			before = middle
+		} else {
+			after = middle
+		}
+	}
+	return after
+}
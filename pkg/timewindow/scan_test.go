@@ -0,0 +1,148 @@
+package timewindow
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEnd(t *testing.T) {
+	overnight, err := Parse("fr 22:00 04:00 Europe/Berlin", "UTC")
+	if err != nil {
+		t.Fatalf("Failed to parse window: %v", err)
+	}
+	cron, err := ParseCron("30 2 * * * 90m", "UTC")
+	if err != nil {
+		t.Fatalf("Failed to parse window: %v", err)
+	}
+	always, err := New(EveryDay, "0:00", "23:59:59", "UTC")
+	if err != nil {
+		t.Fatalf("Failed to parse window: %v", err)
+	}
+
+	tests := []struct {
+		window Window
+		time   string
+		end    string
+		ok     bool
+	}{
+		{overnight, "2019/04/05 20:00 UTC", "2019/04/05 22:00 UTC", true}, // Fri 22:00 CEST, until the end of Friday
+		{overnight, "2019/04/05 01:00 UTC", "2019/04/05 02:00 UTC", true}, // Fri 03:00 CEST
+		{overnight, "2019/04/06 01:00 UTC", "", false},                    // Sat 03:00 CEST
+		{overnight, "2019/03/29 21:30 UTC", "2019/03/29 23:00 UTC", true}, // Fri 22:30 CET
+		{cron, "2019/04/04 02:45 UTC", "2019/04/04 04:00 UTC", true},
+		{cron, "2019/04/04 02:29 UTC", "", false},
+		{always, "2019/04/04 12:00 UTC", "", false},
+	}
+
+	for _, tst := range tests {
+		tm, err := time.Parse("2006/01/02 15:04 MST", tst.time)
+		if err != nil {
+			t.Fatalf("Failed to parse time \"%s\": %v", tst.time, err)
+		}
+
+		end, ok := End(tst.window, tm, 24*time.Hour)
+		if ok != tst.ok {
+			t.Errorf("Test %s at %s: Expected ok %v got %v", tst.window, tst.time, tst.ok, ok)
+			continue
+		}
+		if ok && end.UTC().Format("2006/01/02 15:04 MST") != tst.end {
+			t.Errorf("Test %s at %s: Expected %s got %v", tst.window, tst.time, tst.end, end.UTC())
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	// Covers the switch to daylight saving time on 2019-03-31
+	sunday, err := Parse("su 01:00 04:00 Europe/Berlin", "UTC")
+	if err != nil {
+		t.Fatalf("Failed to parse window: %v", err)
+	}
+	cron, err := ParseCron("CRON_TZ=Europe/Berlin 0 23 * * sat 4h", "UTC")
+	if err != nil {
+		t.Fatalf("Failed to parse window: %v", err)
+	}
+	blackout, err := ParseBlackout("2019-04-07", "Europe/Berlin")
+	if err != nil {
+		t.Fatalf("Failed to parse blackout: %v", err)
+	}
+	withBlackout := NewSchedule(sunday)
+	withBlackout.Exclude(blackout)
+
+	tests := []struct {
+		window   Window
+		from     string
+		expected []string
+	}{
+		{sunday, "2019/03/28 00:00 UTC", []string{
+			"2019-03-31T00:00:00Z 2019-03-31T02:00:01Z", // 01:00 CET - 04:00 CEST
+			"2019-04-06T23:00:00Z 2019-04-07T02:00:01Z",
+			"2019-04-13T23:00:00Z 2019-04-14T02:00:01Z",
+		}},
+		{sunday, "2019/03/31 01:00 UTC", []string{
+			"2019-03-31T01:00:00Z 2019-03-31T02:00:01Z", // already open
+			"2019-04-06T23:00:00Z 2019-04-07T02:00:01Z",
+			"2019-04-13T23:00:00Z 2019-04-14T02:00:01Z",
+		}},
+		{cron, "2019/10/21 00:00 UTC", []string{
+			"2019-10-26T21:00:00Z 2019-10-27T01:00:00Z", // four hours, across the switch back to CET
+			"2019-11-02T22:00:00Z 2019-11-03T02:00:00Z",
+			"2019-11-09T22:00:00Z 2019-11-10T02:00:00Z",
+		}},
+		{withBlackout, "2019/03/28 00:00 UTC", []string{
+			"2019-03-31T00:00:00Z 2019-03-31T02:00:01Z",
+			"2019-04-13T23:00:00Z 2019-04-14T02:00:01Z", // 2019-04-07 blacked out
+			"2019-04-20T23:00:00Z 2019-04-21T02:00:01Z",
+		}},
+	}
+
+	for _, tst := range tests {
+		from, err := time.Parse("2006/01/02 15:04 MST", tst.from)
+		if err != nil {
+			t.Fatalf("Failed to parse time \"%s\": %v", tst.from, err)
+		}
+
+		periods := Next(tst.window, from, len(tst.expected), 60*24*time.Hour)
+		if len(periods) != len(tst.expected) {
+			t.Errorf("Test %s from %s: Expected %d periods got %v", tst.window, tst.from, len(tst.expected), periods)
+			continue
+		}
+		for i, period := range periods {
+			result := period.Start.UTC().Format(time.RFC3339) + " " + period.End.UTC().Format(time.RFC3339)
+			if result != tst.expected[i] {
+				t.Errorf("Test %s from %s: Expected %s got %s", tst.window, tst.from, tst.expected[i], result)
+			}
+		}
+	}
+}
+
+func TestUntil(t *testing.T) {
+	cron, err := ParseCron("0 2 * * * 1h", "UTC")
+	if err != nil {
+		t.Fatalf("Failed to parse window: %v", err)
+	}
+
+	tests := []struct {
+		time  string
+		until time.Duration
+		ok    bool
+	}{
+		{"2019/04/04 00:30 UTC", 90 * time.Minute, true},
+		{"2019/04/04 02:30 UTC", 0, true},
+		{"2019/04/04 03:00 UTC", 23 * time.Hour, true},
+	}
+
+	for _, tst := range tests {
+		tm, err := time.Parse("2006/01/02 15:04 MST", tst.time)
+		if err != nil {
+			t.Fatalf("Failed to parse time \"%s\": %v", tst.time, err)
+		}
+		until, ok := Until(cron, tm, 48*time.Hour)
+		if ok != tst.ok || until != tst.until {
+			t.Errorf("Test %s: Expected %v %v got %v %v", tst.time, tst.until, tst.ok, until, ok)
+		}
+	}
+
+	if _, ok := Until(NewSchedule(), time.Now(), 48*time.Hour); ok {
+		t.Errorf("Expected empty schedule never to open")
+	}
+}
@@ -0,0 +1,91 @@
+package timewindow
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Window is a recurring period of time during which reboots may happen.
+type Window interface {
+	// Contains determines whether the specified time is within the window.
+	Contains(t time.Time) bool
+	// String returns a human readable representation of the window.
+	String() string
+}
+
+// Schedule combines several windows; it contains a time if any of its
+// windows does and none of its blackouts does.
+type Schedule struct {
+	windows   []Window
+	blackouts []Blackout
+}
+
+// NewSchedule creates a schedule from a set of windows.
+func NewSchedule(windows ...Window) *Schedule {
+	return &Schedule{windows: windows}
+}
+
+// WithWindows returns a schedule consisting of the specified windows and the
+// blackouts of this schedule.
+func (s *Schedule) WithWindows(windows ...Window) *Schedule {
+	return &Schedule{windows: windows, blackouts: s.blackouts}
+}
+
+// Exclude adds blackouts to this schedule, overriding its windows.
+func (s *Schedule) Exclude(blackouts ...Blackout) {
+	s.blackouts = append(s.blackouts, blackouts...)
+}
+
+// Blackout returns the blackout containing the specified time, if any.
+func (s *Schedule) Blackout(t time.Time) (Blackout, bool) {
+	for _, b := range s.blackouts {
+		if b.Contains(t) {
+			return b, true
+		}
+	}
+	return Blackout{}, false
+}
+
+// Contains determines whether the specified time is within any window of
+// this schedule and not within any of its blackouts.
+func (s *Schedule) Contains(t time.Time) bool {
+	if _, ok := s.Blackout(t); ok {
+		return false
+	}
+	for _, w := range s.windows {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns a string representation of this schedule.
+func (s *Schedule) String() string {
+	parts := make([]string, 0, len(s.windows))
+	for _, w := range s.windows {
+		parts = append(parts, w.String())
+	}
+	if len(s.blackouts) > 0 {
+		return fmt.Sprintf("%s (except during %d blackouts)", strings.Join(parts, "; "), len(s.blackouts))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Parse creates a TimeWindow from a specification of the form
+// "DAYS START END [TIMEZONE]", e.g. "mon,tue,wed 02:00 05:00 Europe/Berlin".
+// DAYS is a comma separated list of weekdays, and location is used if no
+// TIMEZONE is given.
+func Parse(spec, location string) (*TimeWindow, error) {
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 3:
+	case 4:
+		location = fields[3]
+	default:
+		return nil, fmt.Errorf("Invalid time window, expected \"DAYS START END [TIMEZONE]\": %s", spec)
+	}
+
+	return New(strings.Split(fields[0], ","), fields[1], fields[2], location)
+}
@@ -0,0 +1,126 @@
+package timewindow
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		spec   string
+		result string
+	}{
+		{"mo,tu,we,th,fr 02:00 05:00", "---MonTueWedThuFri--- between 02:00 and 05:00 UTC"},
+		{"sat 10am 6pm Europe/Berlin", "------------------Sat between 10:00 and 18:00 Europe/Berlin"},
+		{"  su   22:00   01:00  ", "Sun------------------ between 22:00 and 01:00 UTC"},
+	}
+
+	for _, tst := range tests {
+		tw, err := Parse(tst.spec, "UTC")
+		if err != nil {
+			t.Errorf("Received error for input %s: %v", tst.spec, err)
+		} else if tw.String() != tst.result {
+			t.Errorf("Test %s: Expected %s got %s", tst.spec, tst.result, tw.String())
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"mon 02:00",
+		"mon 02:00 05:00 UTC extra",
+		"someday 02:00 05:00",
+		"mon 02:00 25:00",
+		"mon 02:00 05:00 Mars/Olympus_Mons",
+	}
+
+	for _, tst := range tests {
+		if _, err := Parse(tst, "UTC"); err == nil {
+			t.Errorf("Expected to receive error for input %s", tst)
+		}
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	weekdays, err := Parse("mo,tu,we,th,fr 02:00 05:00 Europe/Berlin", "UTC")
+	if err != nil {
+		t.Fatalf("Failed to parse window: %v", err)
+	}
+	saturday, err := Parse("sa 10:00 18:00 America/New_York", "UTC")
+	if err != nil {
+		t.Fatalf("Failed to parse window: %v", err)
+	}
+	schedule := NewSchedule(weekdays, saturday)
+
+	tests := []struct {
+		time   string
+		result bool
+	}{
+		{"2019/04/04 01:00 UTC", true},  // Thu 03:00 CEST
+		{"2019/04/04 04:00 UTC", false}, // Thu 06:00 CEST
+		{"2019/04/06 01:00 UTC", false}, // Sat 03:00 CEST
+		{"2019/04/06 15:00 UTC", true},  // Sat 11:00 EDT
+		{"2019/04/06 13:00 UTC", false}, // Sat 09:00 EDT
+		{"2019/04/07 15:00 UTC", false}, // Sun 11:00 EDT
+	}
+
+	for _, tst := range tests {
+		tm, err := time.Parse("2006/01/02 15:04 MST", tst.time)
+		if err != nil {
+			t.Errorf("Failed to parse time \"%s\": %v", tst.time, err)
+		} else if tst.result != schedule.Contains(tm) {
+			t.Errorf("(%s) contains (%s) didn't match expected result of %v", schedule.String(), tst.time, tst.result)
+		}
+	}
+
+	if NewSchedule().Contains(time.Now()) {
+		t.Errorf("Expected empty schedule not to contain any time")
+	}
+	expected := "---MonTueWedThuFri--- between 02:00 and 05:00 Europe/Berlin; ------------------Sat between 10:00 and 18:00 America/New_York"
+	if schedule.String() != expected {
+		t.Errorf("Expected %s got %s", expected, schedule.String())
+	}
+}
+
+func TestScheduleWithWindows(t *testing.T) {
+	weekdays, err := Parse("mo,tu,we,th,fr 02:00 05:00", "UTC")
+	if err != nil {
+		t.Fatalf("Failed to parse window: %v", err)
+	}
+	sunday, err := Parse("su 02:00 05:00", "UTC")
+	if err != nil {
+		t.Fatalf("Failed to parse window: %v", err)
+	}
+	blackout, err := ParseBlackout("2019-04-14", "UTC")
+	if err != nil {
+		t.Fatalf("Failed to parse blackout: %v", err)
+	}
+
+	schedule := NewSchedule(weekdays)
+	schedule.Exclude(blackout)
+	override := schedule.WithWindows(sunday)
+
+	tests := []struct {
+		time   string
+		result bool
+	}{
+		{"2019/04/04 03:00 UTC", false}, // Thu
+		{"2019/04/07 03:00 UTC", true},  // Sun
+		{"2019/04/14 03:00 UTC", false}, // Sun, blacked out
+	}
+
+	for _, tst := range tests {
+		tm, err := time.Parse("2006/01/02 15:04 MST", tst.time)
+		if err != nil {
+			t.Errorf("Failed to parse time \"%s\": %v", tst.time, err)
+		} else if tst.result != override.Contains(tm) {
+			t.Errorf("(%s) contains (%s) didn't match expected result of %v", override.String(), tst.time, tst.result)
+		}
+	}
+
+	expected := "---MonTueWedThuFri--- between 02:00 and 05:00 UTC (except during 1 blackouts)"
+	if schedule.String() != expected {
+		t.Errorf("Expected original schedule to remain %s got %s", expected, schedule.String())
+	}
+}